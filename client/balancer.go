@@ -0,0 +1,202 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/go-kratos/kratos/v2/selector"
+	"github.com/go-kratos/kratos/v2/selector/node/direct"
+	"github.com/go-kratos/kratos/v2/selector/node/ewma"
+	"github.com/go-kratos/kratos/v2/selector/p2c"
+	"github.com/go-kratos/kratos/v2/selector/random"
+	"github.com/go-kratos/kratos/v2/selector/wrr"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+const (
+	policyRoundRobin         = "round_robin"
+	policyWeightedRoundRobin = "weighted_round_robin"
+	policyRandom             = "random"
+	policyLeastRequest       = "least_request"
+	policyP2C                = "p2c"
+	policyRingHash           = "ring_hash"
+	policyMaglev             = "maglev"
+
+	hashOnIP     = "ip"
+	hashOnHeader = "header:"
+	hashOnCookie = "cookie:"
+	hashOnQuery  = "query:"
+)
+
+// loadBalancer returns the balancer and the node builder of the policy.
+func loadBalancer(lb *config.LoadBalancer) (selector.BalancerBuilder, selector.WeightedNodeBuilder, error) {
+	switch lb.Policy {
+	case policyRoundRobin:
+		return &roundRobinBuilder{}, &direct.Builder{}, nil
+	case policyWeightedRoundRobin, wrr.Name:
+		return &wrr.Builder{}, &direct.Builder{}, nil
+	case policyRandom:
+		return &random.Builder{}, &direct.Builder{}, nil
+	case policyLeastRequest:
+		return &leastRequestBuilder{}, &direct.Builder{}, nil
+	case "", policyP2C:
+		return &p2c.Builder{}, &ewma.Builder{}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown load balancer policy: %s", lb.Policy)
+}
+
+// hashTableBuilder returns the function building the lookup table of the hash policies.
+func hashTableBuilder(lb *config.LoadBalancer) (func(nodes []selector.WeightedNode) hashTable, error) {
+	switch lb.Policy {
+	case policyRingHash:
+		virtualNodes := lb.VirtualNodes
+		if virtualNodes <= 0 {
+			virtualNodes = _defaultVirtualNodes
+		}
+		return func(nodes []selector.WeightedNode) hashTable {
+			return newRing(nodes, virtualNodes)
+		}, nil
+	case policyMaglev:
+		tableSize := lb.TableSize
+		if tableSize <= 0 {
+			tableSize = _defaultMaglevTableSize
+		}
+		if !isPrime(tableSize) {
+			return nil, fmt.Errorf("maglev table size must be a prime: %d", tableSize)
+		}
+		return func(nodes []selector.WeightedNode) hashTable {
+			return newMaglev(nodes, tableSize)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown load balancer policy: %s", lb.Policy)
+}
+
+// pickerBuilder returns the selector builder of the load balancer of the endpoint.
+func pickerBuilder(lb *config.LoadBalancer) (selector.Builder, error) {
+	if lb.Policy == policyRingHash || lb.Policy == policyMaglev {
+		build, err := hashTableBuilder(lb)
+		if err != nil {
+			return nil, err
+		}
+		return &hashBuilder{build: build}, nil
+	}
+	balancer, nodeBuilder, err := loadBalancer(lb)
+	if err != nil {
+		return nil, err
+	}
+	if lb.SlowStart != nil && lb.SlowStart.Window > 0 && weighted(lb.Policy) {
+		return &slowStartBuilder{balancer: balancer, node: nodeBuilder, config: lb.SlowStart}, nil
+	}
+	return &selector.DefaultBuilder{Balancer: balancer, Node: nodeBuilder}, nil
+}
+
+// weighted reports whether the policy picks the nodes by their current weight.
+func weighted(policy string) bool {
+	switch policy {
+	case policyWeightedRoundRobin, wrr.Name, policyLeastRequest, "", policyP2C:
+		return true
+	}
+	return false
+}
+
+// hashKeyFunc returns the function extracting the hash key of the requests.
+func hashKeyFunc(lb *config.LoadBalancer) (func(req *http.Request) string, error) {
+	if lb == nil || (lb.Policy != policyRingHash && lb.Policy != policyMaglev) {
+		return nil, nil
+	}
+	hashOn := lb.HashOn
+	switch {
+	case hashOn == "" || hashOn == hashOnIP:
+		return middleware.ClientIP, nil
+	case strings.HasPrefix(hashOn, hashOnHeader):
+		name := hashOn[len(hashOnHeader):]
+		return func(req *http.Request) string {
+			return req.Header.Get(name)
+		}, nil
+	case strings.HasPrefix(hashOn, hashOnCookie):
+		name := hashOn[len(hashOnCookie):]
+		return func(req *http.Request) string {
+			if c, err := req.Cookie(name); err == nil {
+				return c.Value
+			}
+			return ""
+		}, nil
+	case strings.HasPrefix(hashOn, hashOnQuery):
+		name := hashOn[len(hashOnQuery):]
+		return func(req *http.Request) string {
+			return req.URL.Query().Get(name)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown load balancer hash key: %s", hashOn)
+}
+
+type roundRobinBuilder struct{}
+
+func (*roundRobinBuilder) Build() selector.Balancer {
+	return &roundRobinBalancer{}
+}
+
+type roundRobinBalancer struct {
+	next uint64
+}
+
+func (b *roundRobinBalancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+	n := nodes[(atomic.AddUint64(&b.next, 1)-1)%uint64(len(nodes))]
+	return n, n.Pick(), nil
+}
+
+type leastRequestBuilder struct{}
+
+func (*leastRequestBuilder) Build() selector.Balancer {
+	return &leastRequestBalancer{}
+}
+
+// leastRequestBalancer picks the node with the fewest active requests
+// relatively to its weight out of two random ones.
+type leastRequestBalancer struct {
+	active sync.Map
+}
+
+func (b *leastRequestBalancer) counter(n selector.WeightedNode) *int64 {
+	v, ok := b.active.Load(n.Address())
+	if !ok {
+		v, _ = b.active.LoadOrStore(n.Address(), new(int64))
+	}
+	return v.(*int64)
+}
+
+func (b *leastRequestBalancer) Pick(_ context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+	n := nodes[0]
+	if len(nodes) > 1 {
+		i := rand.IntN(len(nodes))
+		j := rand.IntN(len(nodes) - 1)
+		if j >= i {
+			j++
+		}
+		a, c := nodes[i], nodes[j]
+		if float64(atomic.LoadInt64(b.counter(c))+1)/c.Weight() < float64(atomic.LoadInt64(b.counter(a))+1)/a.Weight() {
+			a = c
+		}
+		n = a
+	}
+	active := b.counter(n)
+	atomic.AddInt64(active, 1)
+	done := n.Pick()
+	return n, func(ctx context.Context, di selector.DoneInfo) {
+		atomic.AddInt64(active, -1)
+		done(ctx, di)
+	}, nil
+}
@@ -0,0 +1,204 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/selector"
+	"github.com/go-kratos/kratos/v2/selector/node/direct"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+type testNode struct {
+	address string
+	weight  int64
+}
+
+func (n *testNode) Scheme() string              { return "http" }
+func (n *testNode) Address() string             { return n.address }
+func (n *testNode) ServiceName() string         { return "test" }
+func (n *testNode) InitialWeight() *int64       { return &n.weight }
+func (n *testNode) Version() string             { return "" }
+func (n *testNode) Metadata() map[string]string { return nil }
+
+func newTestNodes(count int, weight int64) []selector.Node {
+	nodes := make([]selector.Node, 0, count)
+	for i := 0; i < count; i++ {
+		nodes = append(nodes, &testNode{address: fmt.Sprintf("10.0.0.%d:80", i), weight: weight})
+	}
+	return nodes
+}
+
+func newTestSelector(t *testing.T, lb *config.LoadBalancer, nodes []selector.Node) selector.Selector {
+	t.Helper()
+	builder, err := pickerBuilder(lb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := builder.Build()
+	s.Apply(nodes)
+	return s
+}
+
+func pick(t *testing.T, s selector.Selector, ctx context.Context) string {
+	t.Helper()
+	n, done, err := s.Select(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done(ctx, selector.DoneInfo{})
+	return n.Address()
+}
+
+func TestLoadBalancerPolicies(t *testing.T) {
+	if _, err := pickerBuilder(&config.LoadBalancer{Policy: "unknown"}); err == nil {
+		t.Fatal("expected unknown policy to fail")
+	}
+	if _, err := pickerBuilder(&config.LoadBalancer{Policy: policyMaglev, TableSize: 1000}); err == nil {
+		t.Fatal("expected non prime maglev table size to fail")
+	}
+	for _, policy := range []string{policyRoundRobin, policyWeightedRoundRobin, policyRandom, policyLeastRequest, policyP2C, policyRingHash, policyMaglev} {
+		s := newTestSelector(t, &config.LoadBalancer{Policy: policy}, newTestNodes(4, 100))
+		counts := map[string]int{}
+		for i := 0; i < 4000; i++ {
+			counts[pick(t, s, context.Background())]++
+		}
+		if len(counts) != 4 {
+			t.Errorf("%s: expected every node to be picked, got %v", policy, counts)
+		}
+	}
+
+	s := newTestSelector(t, &config.LoadBalancer{Policy: policyRoundRobin}, newTestNodes(3, 100))
+	for i := 0; i < 6; i++ {
+		if got, expected := pick(t, s, context.Background()), fmt.Sprintf("10.0.0.%d:80", i%3); got != expected {
+			t.Fatalf("round robin pick %d: expected %s, got %s", i, expected, got)
+		}
+	}
+}
+
+func TestLeastRequest(t *testing.T) {
+	s := newTestSelector(t, &config.LoadBalancer{Policy: policyLeastRequest}, newTestNodes(2, 100))
+	busy, _, err := s.Select(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the busy node is never picked while its request is in flight
+	for i := 0; i < 100; i++ {
+		if got := pick(t, s, context.Background()); got == busy.Address() {
+			t.Fatalf("expected the idle node, got %s", got)
+		}
+	}
+}
+
+func TestHashStability(t *testing.T) {
+	for _, policy := range []string{policyRingHash, policyMaglev} {
+		nodes := newTestNodes(5, 100)
+		s := newTestSelector(t, &config.LoadBalancer{Policy: policy}, nodes)
+		before := map[string]string{}
+		for i := 0; i < 1000; i++ {
+			key := strconv.Itoa(i)
+			ctx := withHashKey(context.Background(), key)
+			before[key] = pick(t, s, ctx)
+			if again := pick(t, s, ctx); again != before[key] {
+				t.Fatalf("%s: key %s moved from %s to %s", policy, key, before[key], again)
+			}
+		}
+
+		removed := nodes[2].Address()
+		s.Apply(append(nodes[:2:2], nodes[3:]...))
+		moved := 0
+		for key, address := range before {
+			got := pick(t, s, withHashKey(context.Background(), key))
+			if got == removed {
+				t.Fatalf("%s: key %s picked the removed node", policy, key)
+			}
+			if address != removed && got != address {
+				moved++
+			}
+		}
+		// only the keys of the removed node are expected to move
+		if moved > len(before)/20 {
+			t.Errorf("%s: %d keys of the remaining nodes moved", policy, moved)
+		}
+	}
+}
+
+func TestHashFilter(t *testing.T) {
+	for _, policy := range []string{policyRingHash, policyMaglev} {
+		s := newTestSelector(t, &config.LoadBalancer{Policy: policy}, newTestNodes(5, 100))
+		table := s.(*hashSelector).balancer.table
+		filtered := "10.0.0.2:80"
+		filter := selector.WithNodeFilter(func(_ context.Context, nodes []selector.Node) []selector.Node {
+			var kept []selector.Node
+			for _, n := range nodes {
+				if n.Address() != filtered {
+					kept = append(kept, n)
+				}
+			}
+			return kept
+		})
+		for i := 0; i < 1000; i++ {
+			ctx := withHashKey(context.Background(), strconv.Itoa(i))
+			address := pick(t, s, ctx)
+			n, done, err := s.Select(ctx, filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done(ctx, selector.DoneInfo{})
+			// only the keys of the filtered node move
+			if got := n.Address(); got == filtered || (address != filtered && got != address) {
+				t.Fatalf("%s: key %d moved from %s to %s", policy, i, address, got)
+			}
+		}
+		if s.(*hashSelector).balancer.table != table {
+			t.Errorf("%s: expected the table to be built on apply only", policy)
+		}
+	}
+}
+
+func TestRingWeights(t *testing.T) {
+	var nodes []selector.WeightedNode
+	for _, n := range append(newTestNodes(1, 10), &testNode{address: "10.0.0.1:80", weight: 5}) {
+		nodes = append(nodes, (&direct.Builder{}).Build(n))
+	}
+	counts := map[string]int{}
+	for _, address := range newRing(nodes, _defaultVirtualNodes).addresses {
+		counts[address]++
+	}
+	// the virtual nodes are relative to the heaviest node
+	if counts["10.0.0.0:80"] != _defaultVirtualNodes || counts["10.0.0.1:80"] != _defaultVirtualNodes/2 {
+		t.Errorf("unexpected virtual nodes: %v", counts)
+	}
+}
+
+func TestHashKeyFunc(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/?user=q", nil)
+	req.Header.Set("X-User", "h")
+	req.AddCookie(&http.Cookie{Name: "user", Value: "c"})
+	tests := map[string]string{
+		"header:X-User": "h",
+		"cookie:user":   "c",
+		"query:user":    "q",
+		"cookie:none":   "",
+	}
+	for hashOn, expected := range tests {
+		fn, err := hashKeyFunc(&config.LoadBalancer{Policy: policyRingHash, HashOn: hashOn})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fn(req); got != expected {
+			t.Errorf("%s: expected %q, got %q", hashOn, expected, got)
+		}
+	}
+	if _, err := hashKeyFunc(&config.LoadBalancer{Policy: policyMaglev, HashOn: "body"}); err == nil {
+		t.Fatal("expected unknown hash key to fail")
+	}
+	if fn, _ := hashKeyFunc(&config.LoadBalancer{Policy: policyRandom}); fn != nil {
+		t.Fatal("expected no hash key for the random policy")
+	}
+}
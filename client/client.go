@@ -23,6 +23,9 @@ const (
 type client struct {
 	applier  *nodeApplier
 	selector selector.Selector
+	outlier  *outlierDetector
+	// hashKey returns the key of the hash load balancers.
+	hashKey func(req *http.Request) string
 }
 
 type Client interface {
@@ -30,15 +33,22 @@ type Client interface {
 	io.Closer
 }
 
-func newClient(applier *nodeApplier, selector selector.Selector) *client {
+func newClient(applier *nodeApplier, selector selector.Selector, outlier *outlierDetector) *client {
 	return &client{
 		applier:  applier,
 		selector: selector,
+		outlier:  outlier,
 	}
 }
 
 func (c *client) Close() error {
 	c.applier.Cancel()
+	if c.outlier != nil {
+		c.outlier.Close()
+	}
+	if closer, ok := c.selector.(io.Closer); ok {
+		closer.Close()
+	}
 	return nil
 }
 
@@ -46,7 +56,17 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	ctx := req.Context()
 	reqOpt, _ := middleware.FromRequestContext(ctx)
 	filter, _ := middleware.SelectorFiltersFromContext(ctx)
-	n, done, err := c.selector.Select(ctx, selector.WithNodeFilter(filter...))
+	if c.outlier != nil {
+		// the ejected nodes are removed before any other filter sees the pool
+		filter = append([]selector.NodeFilter{c.outlier.Filter}, filter...)
+	}
+	selectCtx := ctx
+	if c.hashKey != nil {
+		if key := c.hashKey(req); key != "" {
+			selectCtx = withHashKey(ctx, key)
+		}
+	}
+	n, done, err := c.selector.Select(selectCtx, selector.WithNodeFilter(filter...))
 	if err != nil {
 		return nil, err
 	}
@@ -60,6 +80,9 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	}
 
 	reqOpt.Backends = append(reqOpt.Backends, addr)
+	if reqOpt.OnSelected != nil {
+		reqOpt.OnSelected(addr)
+	}
 	req.URL.Host = addr
 	req.URL.Scheme = "http"
 	req.RequestURI = ""
@@ -69,8 +92,22 @@ func (c *client) RoundTrip(req *http.Request) (*http.Response, error) {
 	// Inject the context into the HTTP headers
 	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
+	if reqOpt.Signer != nil {
+		if err := reqOpt.Signer(req); err != nil {
+			done(ctx, selector.DoneInfo{Err: err})
+			return nil, err
+		}
+	}
+
 	resp, err := n.(*node).client.Do(req)
 	reqOpt.UpstreamResponseTime = append(reqOpt.UpstreamResponseTime, time.Since(startAt).Seconds())
+	if c.outlier != nil && tAddr == "" {
+		statusCode := 0
+		if err == nil {
+			statusCode = resp.StatusCode
+		}
+		c.outlier.Record(n.Address(), statusCode, err)
+	}
 	if err != nil {
 		done(ctx, selector.DoneInfo{Err: err})
 		reqOpt.UpstreamStatusCode = append(reqOpt.UpstreamStatusCode, 0)
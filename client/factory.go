@@ -4,8 +4,10 @@ import (
 	"context"
 	"fmt"
 	"github.com/limes-cloud/gateway/config"
+	"io"
 	"strconv"
 	"strings"
+	"sync"
 	"sync/atomic"
 
 	"github.com/go-kratos/kratos/v2/log"
@@ -37,18 +39,38 @@ func NewFactory(r registry.Discovery, opts ...Option) Factory {
 		opt(o)
 	}
 	return func(endpoint *config.Endpoint) (Client, error) {
-		picker := o.pickerBuilder.Build()
+		builder := o.pickerBuilder
+		if endpoint.LoadBalancer != nil {
+			var err error
+			if builder, err = pickerBuilder(endpoint.LoadBalancer); err != nil {
+				return nil, err
+			}
+		}
+		hashKey, err := hashKeyFunc(endpoint.LoadBalancer)
+		if err != nil {
+			return nil, err
+		}
+		picker := builder.Build()
+		outlier := newOutlierDetector(endpoint)
 		ctx, cancel := context.WithCancel(context.Background())
 		applier := &nodeApplier{
 			cancel:   cancel,
 			endpoint: endpoint,
 			registry: r,
 			picker:   picker,
+			outlier:  outlier,
 		}
 		if err := applier.apply(ctx); err != nil {
+			if outlier != nil {
+				outlier.Close()
+			}
+			if closer, ok := picker.(io.Closer); ok {
+				closer.Close()
+			}
 			return nil, err
 		}
-		client := newClient(applier, picker)
+		client := newClient(applier, picker, outlier)
+		client.hashKey = hashKey
 		return client, nil
 	}
 }
@@ -59,22 +81,57 @@ type nodeApplier struct {
 	endpoint *config.Endpoint
 	registry registry.Discovery
 	picker   selector.Selector
+	outlier  *outlierDetector
+
+	lock sync.Mutex
+	// the nodes, health checks and probes of every backend
+	nodes  [][]selector.Node
+	checks []*config.HealthCheck
+	probes [][]*healthProbe
+}
+
+// targetApplier applies the discovered nodes of a backend.
+type targetApplier struct {
+	*nodeApplier
+	index int
+}
+
+func (ta *targetApplier) Callback(services []*registry.ServiceInstance) error {
+	return ta.callback(ta.index, services)
 }
 
 func (na *nodeApplier) apply(ctx context.Context) error {
-	var nodes []selector.Node
-	for _, backend := range na.endpoint.Backends {
+	na.nodes = make([][]selector.Node, len(na.endpoint.Backends))
+	na.checks = make([]*config.HealthCheck, len(na.endpoint.Backends))
+	na.probes = make([][]*healthProbe, len(na.endpoint.Backends))
+	for i, backend := range na.endpoint.Backends {
+		check := backend.HealthCheck
+		if check == nil {
+			check = na.endpoint.HealthCheck
+		}
+		if check != nil {
+			opts, err := healthCheckOptions(check, na.endpoint.Protocol)
+			if err != nil {
+				return err
+			}
+			na.checks[i] = opts
+		}
+	}
+	for i, backend := range na.endpoint.Backends {
 		target, err := parseTarget(backend.Target)
 		if err != nil {
 			return err
 		}
 		switch target.Scheme {
 		case "direct":
-			node := newNode(backend.Target, na.endpoint.Protocol, backend.Weight, map[string]string{}, "", "")
-			nodes = append(nodes, node)
-			na.picker.Apply(nodes)
+			md := backend.Metadata
+			if md == nil {
+				md = map[string]string{}
+			}
+			node := newNode(backend.Target, na.endpoint.Protocol, backend.Weight, md, backend.Version, "")
+			na.setNodes(i, []selector.Node{node})
 		case "discovery":
-			existed := AddWatch(ctx, na.registry, target.Endpoint, na)
+			existed := AddWatch(ctx, na.registry, target.Endpoint, &targetApplier{nodeApplier: na, index: i})
 			if existed {
 				log.Infof("watch target %+v already existed", target)
 			}
@@ -85,6 +142,62 @@ func (na *nodeApplier) apply(ctx context.Context) error {
 	return nil
 }
 
+// setNodes replaces the nodes of the backend and follows their health.
+func (na *nodeApplier) setNodes(index int, nodes []selector.Node) {
+	na.lock.Lock()
+	old := na.probes[index]
+	// the nodes of the first update of a backend are warm, even when the
+	// other backends were applied before
+	if w, ok := na.picker.(warmer); ok && na.nodes[index] == nil {
+		w.warm(nodes)
+	}
+	na.nodes[index] = nodes
+	na.probes[index] = nil
+	if check := na.checks[index]; check != nil {
+		probes := make([]*healthProbe, 0, len(nodes))
+		for _, n := range nodes {
+			probes = append(probes, _healthRegistry.acquire(n.(*node), check, na))
+		}
+		na.probes[index] = probes
+	}
+	na.lock.Unlock()
+	// the probes are released after the new ones are acquired to keep the shared ones running
+	for _, p := range old {
+		_healthRegistry.release(p, na)
+	}
+	na.applyHealthy()
+}
+
+// applyHealthy applies the healthy nodes, or every node if none is healthy.
+func (na *nodeApplier) applyHealthy() {
+	na.lock.Lock()
+	defer na.lock.Unlock()
+	var all, healthy []selector.Node
+	complete := true
+	for i, nodes := range na.nodes {
+		complete = complete && nodes != nil
+		all = append(all, nodes...)
+		if na.probes[i] == nil {
+			healthy = append(healthy, nodes...)
+			continue
+		}
+		for j, n := range nodes {
+			if na.probes[i][j].Healthy() {
+				healthy = append(healthy, n)
+			}
+		}
+	}
+	if len(healthy) == 0 && len(all) > 0 {
+		log.Warnf("no healthy node for endpoint %s %s, using all the %d nodes", na.endpoint.Method, na.endpoint.Path, len(all))
+		healthy = all
+	}
+	// the hosts kept from the previous clients are pruned once every backend is known
+	if na.outlier != nil && complete {
+		na.outlier.Prune(all)
+	}
+	na.picker.Apply(healthy)
+}
+
 var _defaultWeight = int64(10)
 
 func nodeWeight(n *registry.ServiceInstance) *int64 {
@@ -99,7 +212,7 @@ func nodeWeight(n *registry.ServiceInstance) *int64 {
 	return &_defaultWeight
 }
 
-func (na *nodeApplier) Callback(services []*registry.ServiceInstance) error {
+func (na *nodeApplier) callback(index int, services []*registry.ServiceInstance) error {
 	if atomic.LoadInt64(&na.canceled) == 1 {
 		return ErrCancelWatch
 	}
@@ -117,7 +230,7 @@ func (na *nodeApplier) Callback(services []*registry.ServiceInstance) error {
 		node := newNode(addr, na.endpoint.Protocol, nodeWeight(ser), ser.Metadata, ser.Version, ser.Name)
 		nodes = append(nodes, node)
 	}
-	na.picker.Apply(nodes)
+	na.setNodes(index, nodes)
 	return nil
 }
 
@@ -125,6 +238,15 @@ func (na *nodeApplier) Cancel() {
 	log.Infof("Closing node applier for endpoint: %+v", na.endpoint)
 	atomic.StoreInt64(&na.canceled, 1)
 	na.cancel()
+	na.lock.Lock()
+	probes := na.probes
+	na.probes = make([][]*healthProbe, len(probes))
+	na.lock.Unlock()
+	for _, backend := range probes {
+		for _, p := range backend {
+			_healthRegistry.release(p, na)
+		}
+	}
 }
 
 func (na *nodeApplier) Canceled() bool {
@@ -0,0 +1,271 @@
+package client
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/go-kratos/kratos/v2/selector"
+	"github.com/go-kratos/kratos/v2/selector/node/direct"
+)
+
+const (
+	_defaultVirtualNodes    = 160
+	_defaultMaglevTableSize = 65537
+)
+
+type hashKey struct{}
+
+// withHashKey returns the context carrying the hash of the key of the request.
+func withHashKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, hashKey{}, xxhash.Sum64String(key))
+}
+
+func hashFromContext(ctx context.Context) (uint64, bool) {
+	h, ok := ctx.Value(hashKey{}).(uint64)
+	return h, ok
+}
+
+// hashTable maps the hash of a key to the address of a node, the next
+// candidates are walked until one is accepted.
+type hashTable interface {
+	Lookup(hash uint64, accept func(address string) bool) string
+}
+
+// hashBuilder builds the selectors of the hash policies, their table is built
+// when the nodes are applied rather than on the requests.
+type hashBuilder struct {
+	build func(nodes []selector.WeightedNode) hashTable
+}
+
+func (b *hashBuilder) Build() selector.Selector {
+	balancer := &hashBalancer{}
+	node := &direct.Builder{}
+	builder := &selector.DefaultBuilder{Balancer: balancer, Node: node}
+	return &hashSelector{Selector: builder.Build(), balancer: balancer, node: node, build: b.build}
+}
+
+// hashSelector builds the table of the nodes before they are applied.
+type hashSelector struct {
+	selector.Selector
+	balancer *hashBalancer
+	node     selector.WeightedNodeBuilder
+	build    func(nodes []selector.WeightedNode) hashTable
+}
+
+func (s *hashSelector) Apply(nodes []selector.Node) {
+	weighted := make([]selector.WeightedNode, 0, len(nodes))
+	for _, n := range nodes {
+		weighted = append(weighted, s.node.Build(n))
+	}
+	s.balancer.setTable(s.build(weighted))
+	s.Selector.Apply(nodes)
+}
+
+// hashBalancer picks the node of the hash of the request key, the requests
+// without key are spread randomly.
+type hashBalancer struct {
+	lock  sync.RWMutex
+	table hashTable
+}
+
+// Build returns the balancer itself, it shares its table with the selector.
+func (b *hashBalancer) Build() selector.Balancer {
+	return b
+}
+
+func (b *hashBalancer) setTable(table hashTable) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.table = table
+}
+
+func (b *hashBalancer) Pick(ctx context.Context, nodes []selector.WeightedNode) (selector.WeightedNode, selector.DoneFunc, error) {
+	if len(nodes) == 0 {
+		return nil, nil, selector.ErrNoAvailable
+	}
+	n := nodes[rand.IntN(len(nodes))]
+	b.lock.RLock()
+	table := b.table
+	b.lock.RUnlock()
+	if h, ok := hashFromContext(ctx); ok && len(nodes) > 1 && table != nil {
+		// the nodes removed by the filters are skipped, the keys of the
+		// other nodes stay in place
+		candidates := make(map[string]selector.WeightedNode, len(nodes))
+		for _, candidate := range nodes {
+			candidates[candidate.Address()] = candidate
+		}
+		address := table.Lookup(h, func(address string) bool {
+			_, ok := candidates[address]
+			return ok
+		})
+		if candidate, ok := candidates[address]; ok {
+			n = candidate
+		}
+	}
+	return n, n.Pick(), nil
+}
+
+// sortedNodes returns the addresses and the weights of the nodes ordered by address.
+func sortedNodes(nodes []selector.WeightedNode) ([]string, []float64) {
+	sorted := slices.Clone(nodes)
+	slices.SortFunc(sorted, func(a, b selector.WeightedNode) int {
+		return strings.Compare(a.Address(), b.Address())
+	})
+	addresses := make([]string, len(sorted))
+	weights := make([]float64, len(sorted))
+	for i, n := range sorted {
+		addresses[i] = n.Address()
+		weights[i] = max(n.Weight(), 0)
+	}
+	return addresses, weights
+}
+
+// ring is a consistent hash ring, each node owns virtual nodes in proportion
+// to its weight relatively to the heaviest node, the keys of the other nodes
+// only move when the heaviest weight changes.
+type ring struct {
+	hashes    []uint64
+	addresses []string
+}
+
+func newRing(nodes []selector.WeightedNode, virtualNodes int) *ring {
+	addresses, weights := sortedNodes(nodes)
+	r := &ring{}
+	var maxWeight float64
+	for _, w := range weights {
+		maxWeight = max(maxWeight, w)
+	}
+	type point struct {
+		hash    uint64
+		address string
+	}
+	points := make([]point, 0, virtualNodes*len(addresses))
+	for i, address := range addresses {
+		vnodes := virtualNodes
+		if maxWeight > 0 {
+			vnodes = max(1, int(math.Round(float64(virtualNodes)*weights[i]/maxWeight)))
+		}
+		for j := 0; j < vnodes; j++ {
+			points = append(points, point{hash: xxhash.Sum64String(address + "_" + strconv.Itoa(j)), address: address})
+		}
+	}
+	slices.SortFunc(points, func(a, b point) int {
+		if a.hash != b.hash {
+			if a.hash < b.hash {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(a.address, b.address)
+	})
+	r.hashes = make([]uint64, len(points))
+	r.addresses = make([]string, len(points))
+	for i, p := range points {
+		r.hashes[i] = p.hash
+		r.addresses[i] = p.address
+	}
+	return r
+}
+
+// Lookup returns the first accepted node clockwise of the hash.
+func (r *ring) Lookup(hash uint64, accept func(address string) bool) string {
+	if len(r.hashes) == 0 {
+		return ""
+	}
+	i, _ := slices.BinarySearch(r.hashes, hash)
+	for range r.hashes {
+		if i == len(r.hashes) {
+			i = 0
+		}
+		if accept(r.addresses[i]) {
+			return r.addresses[i]
+		}
+		i++
+	}
+	return ""
+}
+
+// maglev is the lookup table of Maglev hashing, the nodes fill the table
+// following their own permutation in proportion to their weight.
+type maglev struct {
+	table []string
+}
+
+func newMaglev(nodes []selector.WeightedNode, size int) *maglev {
+	addresses, weights := sortedNodes(nodes)
+	m := &maglev{table: make([]string, size)}
+	if len(addresses) == 0 {
+		return m
+	}
+	var maxWeight float64
+	for _, w := range weights {
+		maxWeight = max(maxWeight, w)
+	}
+	if maxWeight == 0 {
+		for i := range weights {
+			weights[i] = 1
+		}
+		maxWeight = 1
+	}
+	offsets := make([]uint64, len(addresses))
+	skips := make([]uint64, len(addresses))
+	next := make([]uint64, len(addresses))
+	targets := make([]float64, len(addresses))
+	for i, address := range addresses {
+		offsets[i] = xxhash.Sum64String(address) % uint64(size)
+		skips[i] = xxhash.Sum64String(address+"#")%uint64(size-1) + 1
+	}
+	filled := 0
+	for iteration := 1.0; filled < size; iteration++ {
+		for i, address := range addresses {
+			// a node takes a slot every maxWeight/weight iterations
+			if iteration*weights[i] < targets[i] {
+				continue
+			}
+			targets[i] += maxWeight
+			for {
+				c := (offsets[i] + next[i]*skips[i]) % uint64(size)
+				next[i]++
+				if m.table[c] == "" {
+					m.table[c] = address
+					filled++
+					break
+				}
+			}
+			if filled == size {
+				break
+			}
+		}
+	}
+	return m
+}
+
+// Lookup returns the node of the slot of the hash, or of the next slots
+// if it is not accepted.
+func (m *maglev) Lookup(hash uint64, accept func(address string) bool) string {
+	size := uint64(len(m.table))
+	for i := uint64(0); i < size; i++ {
+		if address := m.table[(hash+i)%size]; address != "" && accept(address) {
+			return address
+		}
+	}
+	return ""
+}
+
+func isPrime(n int) bool {
+	if n < 2 {
+		return false
+	}
+	for i := 2; i*i <= n; i++ {
+		if n%i == 0 {
+			return false
+		}
+	}
+	return true
+}
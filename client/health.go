@@ -0,0 +1,388 @@
+package client
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/consts"
+)
+
+const (
+	healthCheckHTTP = "http"
+	healthCheckGRPC = "grpc"
+	healthCheckTCP  = "tcp"
+
+	_defaultHealthCheckInterval = 10 * time.Second
+	_defaultHealthCheckTimeout  = 2 * time.Second
+	_defaultHealthyThreshold    = 2
+	_defaultUnhealthyThreshold  = 3
+
+	// grpcHealthServing is the SERVING status of grpc.health.v1.HealthCheckResponse.
+	grpcHealthServing = 1
+)
+
+var _metricNodeHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "go",
+	Subsystem: "gateway",
+	Name:      "upstream_node_healthy",
+	Help:      "Whether the upstream node passes its active health check",
+}, []string{"service", "address", "check"})
+
+func init() {
+	prometheus.MustRegister(_metricNodeHealthy)
+}
+
+var _healthHTTPClient = &http.Client{
+	CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Transport: &http.Transport{
+		DialContext:     (&net.Dialer{Timeout: _dialTimeout}).DialContext,
+		MaxIdleConns:    1000,
+		IdleConnTimeout: 90 * time.Second,
+	},
+}
+
+// healthCheckOptions fills the defaults of the check of the nodes of the protocol.
+func healthCheckOptions(in *config.HealthCheck, protocol string) (*config.HealthCheck, error) {
+	check := *in
+	if check.Type == "" {
+		check.Type = healthCheckHTTP
+		if protocol == consts.GRPC {
+			check.Type = healthCheckGRPC
+		}
+	}
+	switch check.Type {
+	case healthCheckHTTP:
+		if check.Path == "" {
+			check.Path = "/"
+		}
+	case healthCheckGRPC, healthCheckTCP:
+	default:
+		return nil, fmt.Errorf("unknown health check type: %s", check.Type)
+	}
+	if check.Interval <= 0 {
+		check.Interval = _defaultHealthCheckInterval
+	}
+	if check.Timeout <= 0 {
+		check.Timeout = _defaultHealthCheckTimeout
+	}
+	if check.HealthyThreshold <= 0 {
+		check.HealthyThreshold = _defaultHealthyThreshold
+	}
+	if check.UnhealthyThreshold <= 0 {
+		check.UnhealthyThreshold = _defaultUnhealthyThreshold
+	}
+	return &check, nil
+}
+
+func checkHTTP(ctx context.Context, address string, check *config.HealthCheck) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+address+check.Path, nil)
+	if err != nil {
+		return err
+	}
+	if check.Host != "" {
+		req.Host = check.Host
+	}
+	resp, err := _healthHTTPClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	if len(check.ExpectedStatuses) > 0 {
+		if !slices.Contains(check.ExpectedStatuses, resp.StatusCode) {
+			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+		return nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// checkGRPC calls grpc.health.v1.Health/Check, the messages are encoded by hand
+// since they only have a single field.
+func checkGRPC(ctx context.Context, address string, check *config.HealthCheck) error {
+	var msg []byte
+	if check.Service != "" {
+		msg = append([]byte{0x0a}, binary.AppendUvarint(nil, uint64(len(check.Service)))...)
+		msg = append(msg, check.Service...)
+	}
+	body := make([]byte, 5, 5+len(msg))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(msg)))
+	body = append(body, msg...)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+address+"/grpc.health.v1.Health/Check", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/grpc")
+	req.Header.Set("TE", "trailers")
+	resp, err := _globalH2Client.Transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	reply, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	status := resp.Trailer.Get("Grpc-Status")
+	if status == "" {
+		// trailers-only response
+		status = resp.Header.Get("Grpc-Status")
+	}
+	if status != "0" {
+		return fmt.Errorf("grpc status %s: %s", status, resp.Trailer.Get("Grpc-Message")+resp.Header.Get("Grpc-Message"))
+	}
+	if len(reply) < 5 {
+		return errors.New("empty health check response")
+	}
+	reply = reply[5:]
+	// the status is the varint field 1 of the response
+	var serving uint64
+	if len(reply) > 1 && reply[0] == 0x08 {
+		serving, _ = binary.Uvarint(reply[1:])
+	}
+	if serving != grpcHealthServing {
+		return fmt.Errorf("grpc health status %d", serving)
+	}
+	return nil
+}
+
+func checkTCP(ctx context.Context, address string, _ *config.HealthCheck) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+type healthCheckFunc func(ctx context.Context, address string, check *config.HealthCheck) error
+
+var _healthCheckFuncs = map[string]healthCheckFunc{
+	healthCheckHTTP: checkHTTP,
+	healthCheckGRPC: checkGRPC,
+	healthCheckTCP:  checkTCP,
+}
+
+// healthProbe checks a node periodically, it is shared by the endpoints
+// checking the same node the same way.
+type healthProbe struct {
+	key     string
+	address string
+	service string
+	check   *config.HealthCheck
+	cancel  context.CancelFunc
+
+	lock        sync.Mutex
+	refs        int
+	subscribers map[*nodeApplier]int
+	healthy     bool
+	successes   int
+	failures    int
+	lastCheck   time.Time
+	lastError   string
+}
+
+// HealthStatus is the health check state of a node.
+type HealthStatus struct {
+	Address   string    `json:"address"`
+	Service   string    `json:"service"`
+	Check     string    `json:"check"`
+	Healthy   bool      `json:"healthy"`
+	Successes int       `json:"successes"`
+	Failures  int       `json:"failures"`
+	LastCheck time.Time `json:"lastCheck"`
+	LastError string    `json:"lastError,omitempty"`
+	Endpoints int       `json:"endpoints"`
+}
+
+func (p *healthProbe) Healthy() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.healthy
+}
+
+func (p *healthProbe) status() HealthStatus {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return HealthStatus{
+		Address:   p.address,
+		Service:   p.service,
+		Check:     p.check.Type,
+		Healthy:   p.healthy,
+		Successes: p.successes,
+		Failures:  p.failures,
+		LastCheck: p.lastCheck,
+		LastError: p.lastError,
+		Endpoints: p.refs,
+	}
+}
+
+func (p *healthProbe) setGauge(healthy bool) {
+	v := 0.0
+	if healthy {
+		v = 1
+	}
+	_metricNodeHealthy.WithLabelValues(p.service, p.address, p.check.Type).Set(v)
+}
+
+// record updates the state with a check result, the subscribers are returned
+// when the node has changed of state.
+func (p *healthProbe) record(err error) []*nodeApplier {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.refs <= 0 {
+		// released while checking
+		return nil
+	}
+	p.lastCheck = time.Now()
+	changed := false
+	if err == nil {
+		p.lastError = ""
+		p.failures = 0
+		p.successes++
+		if !p.healthy && p.successes >= p.check.HealthyThreshold {
+			p.healthy, changed = true, true
+			log.Infof("upstream node %s is healthy", p.address)
+		}
+	} else {
+		p.lastError = err.Error()
+		p.successes = 0
+		p.failures++
+		if p.healthy && p.failures >= p.check.UnhealthyThreshold {
+			p.healthy, changed = false, true
+			log.Warnf("upstream node %s is unhealthy: %v", p.address, err)
+		}
+	}
+	if !changed {
+		return nil
+	}
+	p.setGauge(p.healthy)
+	subscribers := make([]*nodeApplier, 0, len(p.subscribers))
+	for na := range p.subscribers {
+		subscribers = append(subscribers, na)
+	}
+	return subscribers
+}
+
+func (p *healthProbe) run(ctx context.Context) {
+	fn := _healthCheckFuncs[p.check.Type]
+	ticker := time.NewTicker(p.check.Interval)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, p.check.Timeout)
+		err := fn(checkCtx, p.address, p.check)
+		cancel()
+		if ctx.Err() != nil {
+			return
+		}
+		for _, na := range p.record(err) {
+			na.applyHealthy()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type healthRegistry struct {
+	lock   sync.Mutex
+	probes map[string]*healthProbe
+}
+
+var _healthRegistry = &healthRegistry{probes: map[string]*healthProbe{}}
+
+func healthProbeKey(address string, check *config.HealthCheck) string {
+	b, _ := json.Marshal(check)
+	return address + "|" + string(b)
+}
+
+// acquire returns the probe of the node, it is started by its first subscriber.
+func (r *healthRegistry) acquire(n *node, check *config.HealthCheck, na *nodeApplier) *healthProbe {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	key := healthProbeKey(n.address, check)
+	p, ok := r.probes[key]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		p = &healthProbe{
+			key:         key,
+			address:     n.address,
+			service:     n.name,
+			check:       check,
+			cancel:      cancel,
+			subscribers: map[*nodeApplier]int{},
+			// nodes are healthy until proven otherwise, not to drop the traffic at startup
+			healthy: true,
+		}
+		r.probes[key] = p
+		p.setGauge(true)
+		go p.run(ctx)
+	}
+	p.lock.Lock()
+	p.refs++
+	p.subscribers[na]++
+	p.lock.Unlock()
+	return p
+}
+
+// release stops the probe once it has no subscriber left.
+func (r *healthRegistry) release(p *healthProbe, na *nodeApplier) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	p.lock.Lock()
+	p.refs--
+	if p.subscribers[na]--; p.subscribers[na] <= 0 {
+		delete(p.subscribers, na)
+	}
+	refs := p.refs
+	p.lock.Unlock()
+	if refs > 0 {
+		return
+	}
+	p.cancel()
+	delete(r.probes, p.key)
+	_metricNodeHealthy.DeleteLabelValues(p.service, p.address, p.check.Type)
+}
+
+func (r *healthRegistry) statuses() []HealthStatus {
+	r.lock.Lock()
+	probes := make([]*healthProbe, 0, len(r.probes))
+	for _, p := range r.probes {
+		probes = append(probes, p)
+	}
+	r.lock.Unlock()
+	statuses := make([]HealthStatus, 0, len(probes))
+	for _, p := range probes {
+		statuses = append(statuses, p.status())
+	}
+	slices.SortFunc(statuses, func(a, b HealthStatus) int {
+		return cmp.Or(strings.Compare(a.Address, b.Address), strings.Compare(a.Check, b.Check))
+	})
+	return statuses
+}
@@ -0,0 +1,165 @@
+package client
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/selector"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/consts"
+)
+
+type recordPicker struct {
+	selector.Selector
+	lock  sync.Mutex
+	nodes []selector.Node
+}
+
+func (p *recordPicker) Apply(nodes []selector.Node) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.nodes = nodes
+}
+
+func (p *recordPicker) addresses() []string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	addrs := make([]string, 0, len(p.nodes))
+	for _, n := range p.nodes {
+		addrs = append(addrs, n.Address())
+	}
+	return addrs
+}
+
+func waitAddresses(t *testing.T, p *recordPicker, expected ...string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := p.addresses()
+		if len(got) == len(expected) {
+			match := true
+			for i := range got {
+				match = match && got[i] == expected[i]
+			}
+			if match {
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected nodes %v, got %v", expected, got)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func newTestApplier(t *testing.T, e *config.Endpoint) *recordPicker {
+	picker := &recordPicker{}
+	na := &nodeApplier{endpoint: e, picker: picker, cancel: func() {}}
+	if err := na.apply(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(na.Cancel)
+	return picker
+}
+
+func TestHTTPHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+	healthy.Store(true)
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" || !healthy.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer flaky.Close()
+	stable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer stable.Close()
+
+	check := &config.HealthCheck{Path: "/healthz", Interval: 10 * time.Millisecond, HealthyThreshold: 2, UnhealthyThreshold: 2}
+	e := &config.Endpoint{
+		Protocol: "HTTP",
+		Backends: []config.Backend{
+			{Target: flaky.Listener.Addr().String()},
+			{Target: stable.Listener.Addr().String()},
+		},
+		HealthCheck: check,
+	}
+	picker := newTestApplier(t, e)
+	waitAddresses(t, picker, flaky.Listener.Addr().String(), stable.Listener.Addr().String())
+
+	healthy.Store(false)
+	waitAddresses(t, picker, stable.Listener.Addr().String())
+	statuses := _healthRegistry.statuses()
+	if len(statuses) != 2 {
+		t.Fatalf("expected 2 probes, got %+v", statuses)
+	}
+
+	healthy.Store(true)
+	waitAddresses(t, picker, flaky.Listener.Addr().String(), stable.Listener.Addr().String())
+}
+
+func TestTCPHealthCheckFallback(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	e := &config.Endpoint{
+		Protocol: "HTTP",
+		Backends: []config.Backend{{
+			Target:      addr,
+			HealthCheck: &config.HealthCheck{Type: healthCheckTCP, Interval: 10 * time.Millisecond, UnhealthyThreshold: 1},
+		}},
+	}
+	picker := newTestApplier(t, e)
+	time.Sleep(50 * time.Millisecond)
+	// the only node is kept when every node is unhealthy
+	waitAddresses(t, picker, addr)
+	for _, s := range _healthRegistry.statuses() {
+		if s.Address == addr && s.Healthy {
+			t.Fatalf("expected %s to be unhealthy: %+v", addr, s)
+		}
+	}
+}
+
+func TestGRPCHealthCheck(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := health.NewServer()
+	srv := grpc.NewServer()
+	healthpb.RegisterHealthServer(srv, hs)
+	go srv.Serve(ln)
+	defer srv.Stop()
+
+	addr := ln.Addr().String()
+	check := &config.HealthCheck{Service: "report", Timeout: time.Second}
+	opts, err := healthCheckOptions(check, consts.GRPC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Type != healthCheckGRPC {
+		t.Fatalf("expected grpc check for grpc endpoints, got %s", opts.Type)
+	}
+	if err := checkGRPC(t.Context(), addr, opts); err == nil {
+		t.Fatal("expected unknown service to fail")
+	}
+	hs.SetServingStatus("report", healthpb.HealthCheckResponse_SERVING)
+	if err := checkGRPC(t.Context(), addr, opts); err != nil {
+		t.Fatal(err)
+	}
+	hs.SetServingStatus("report", healthpb.HealthCheckResponse_NOT_SERVING)
+	if err := checkGRPC(t.Context(), addr, opts); err == nil {
+		t.Fatal("expected not serving to fail")
+	}
+}
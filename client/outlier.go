@@ -0,0 +1,266 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/selector"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+const (
+	_defaultConsecutive5xx           = 5
+	_defaultConsecutiveGatewayErrors = 5
+	_defaultBaseEjectionTime         = 30 * time.Second
+	_defaultMaxEjectionTime          = 300 * time.Second
+	_defaultMaxEjectionPercent       = 10
+
+	ejectReason5xx          = "consecutive_5xx"
+	ejectReasonGatewayError = "consecutive_gateway_errors"
+)
+
+var _metricOutlierEjections = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "go",
+	Subsystem: "gateway",
+	Name:      "upstream_outlier_ejections_total",
+	Help:      "The total number of upstream nodes ejected by the outlier detection",
+}, []string{"service", "address", "reason"})
+
+func init() {
+	prometheus.MustRegister(_metricOutlierEjections)
+}
+
+type outlierHost struct {
+	consecutive5xx           int
+	consecutiveGatewayErrors int
+	ejections                int
+	ejectedUntil             time.Time
+	lastEjection             time.Time
+}
+
+// outlierDetector ejects the nodes of an endpoint failing in a row, the
+// ejection time grows with the number of ejections of the node.
+type outlierDetector struct {
+	service                  string
+	consecutive5xx           int
+	consecutiveGatewayErrors int
+	baseEjectionTime         time.Duration
+	maxEjectionTime          time.Duration
+	maxEjectionPercent       int
+	now                      func() time.Time
+
+	// poolSize is the number of nodes last seen by the filter.
+	poolSize int64
+	*outlierState
+}
+
+// outlierState holds the hosts of a service, it is shared by the detectors of
+// its endpoints and kept across reloads, like the health probes.
+type outlierState struct {
+	key   string
+	refs  int
+	lock  sync.Mutex
+	hosts map[string]*outlierHost
+}
+
+type outlierRegistry struct {
+	lock   sync.Mutex
+	states map[string]*outlierState
+}
+
+var _outlierRegistry = &outlierRegistry{states: map[string]*outlierState{}}
+
+// outlierKey identifies the service of the endpoint, or its backends
+// when it has no service name.
+func outlierKey(e *config.Endpoint) string {
+	if service := e.Metadata["service"]; service != "" {
+		return service
+	}
+	targets := make([]string, 0, len(e.Backends))
+	for _, b := range e.Backends {
+		targets = append(targets, b.Target)
+	}
+	slices.Sort(targets)
+	return strings.Join(targets, ",")
+}
+
+func (r *outlierRegistry) acquire(key string) *outlierState {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s, ok := r.states[key]
+	if !ok {
+		s = &outlierState{key: key, hosts: map[string]*outlierHost{}}
+		r.states[key] = s
+	}
+	s.refs++
+	return s
+}
+
+// release drops the state once no detector uses it.
+func (r *outlierRegistry) release(s *outlierState) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s.refs--; s.refs <= 0 {
+		delete(r.states, s.key)
+	}
+}
+
+func newOutlierDetector(e *config.Endpoint) *outlierDetector {
+	if e.OutlierDetection == nil {
+		return nil
+	}
+	c := e.OutlierDetection
+	d := &outlierDetector{
+		service:                  e.Metadata["service"],
+		consecutive5xx:           c.Consecutive5xx,
+		consecutiveGatewayErrors: c.ConsecutiveGatewayErrors,
+		baseEjectionTime:         c.BaseEjectionTime,
+		maxEjectionTime:          c.MaxEjectionTime,
+		maxEjectionPercent:       c.MaxEjectionPercent,
+		now:                      time.Now,
+		outlierState:             _outlierRegistry.acquire(outlierKey(e)),
+	}
+	if d.consecutive5xx <= 0 {
+		d.consecutive5xx = _defaultConsecutive5xx
+	}
+	if d.consecutiveGatewayErrors <= 0 {
+		d.consecutiveGatewayErrors = _defaultConsecutiveGatewayErrors
+	}
+	if d.baseEjectionTime <= 0 {
+		d.baseEjectionTime = _defaultBaseEjectionTime
+	}
+	if d.maxEjectionTime < d.baseEjectionTime {
+		d.maxEjectionTime = max(_defaultMaxEjectionTime, d.baseEjectionTime)
+	}
+	if d.maxEjectionPercent <= 0 || d.maxEjectionPercent > 100 {
+		d.maxEjectionPercent = _defaultMaxEjectionPercent
+	}
+	return d
+}
+
+// Close releases the state of the hosts.
+func (d *outlierDetector) Close() {
+	_outlierRegistry.release(d.outlierState)
+}
+
+// Prune forgets the hosts which are no longer among the nodes.
+func (d *outlierDetector) Prune(nodes []selector.Node) {
+	addresses := make(map[string]struct{}, len(nodes))
+	for _, n := range nodes {
+		addresses[n.Address()] = struct{}{}
+	}
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	for address := range d.hosts {
+		if _, ok := addresses[address]; !ok {
+			delete(d.hosts, address)
+		}
+	}
+}
+
+// Filter removes the ejected nodes, every node is kept if all are ejected.
+func (d *outlierDetector) Filter(_ context.Context, nodes []selector.Node) []selector.Node {
+	atomic.StoreInt64(&d.poolSize, int64(len(nodes)))
+	now := d.now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	var filtered []selector.Node
+	for i, n := range nodes {
+		h, ok := d.hosts[n.Address()]
+		if ok && now.Before(h.ejectedUntil) {
+			if filtered == nil {
+				filtered = append(make([]selector.Node, 0, len(nodes)), nodes[:i]...)
+			}
+			continue
+		}
+		if filtered != nil {
+			filtered = append(filtered, n)
+		}
+	}
+	if len(filtered) == 0 {
+		return nodes
+	}
+	return filtered
+}
+
+func isGatewayError(statusCode int, err error) bool {
+	if err != nil {
+		return true
+	}
+	return statusCode == http.StatusBadGateway || statusCode == http.StatusServiceUnavailable || statusCode == http.StatusGatewayTimeout
+}
+
+// Record accounts the result of a request sent to the node.
+func (d *outlierDetector) Record(address string, statusCode int, err error) {
+	if errors.Is(err, context.Canceled) {
+		// the client went away, the node is not to blame
+		return
+	}
+	now := d.now()
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	h, ok := d.hosts[address]
+	if !ok {
+		h = &outlierHost{}
+		d.hosts[address] = h
+	}
+	if err == nil && statusCode < http.StatusInternalServerError {
+		h.consecutive5xx = 0
+		h.consecutiveGatewayErrors = 0
+		// the ejection time goes back to the base once the node behaves for a while
+		if h.ejections > 0 && now.Sub(h.lastEjection) > d.maxEjectionTime+d.baseEjectionTime*time.Duration(h.ejections) {
+			h.ejections = 0
+		}
+		return
+	}
+	if now.Before(h.ejectedUntil) {
+		// requests sent before the ejection
+		return
+	}
+	if err == nil {
+		h.consecutive5xx++
+	}
+	if isGatewayError(statusCode, err) {
+		h.consecutiveGatewayErrors++
+	}
+	switch {
+	case h.consecutiveGatewayErrors >= d.consecutiveGatewayErrors:
+		d.eject(address, h, now, ejectReasonGatewayError)
+	case h.consecutive5xx >= d.consecutive5xx:
+		d.eject(address, h, now, ejectReason5xx)
+	}
+}
+
+// eject ejects the node unless too many nodes are already ejected.
+func (d *outlierDetector) eject(address string, h *outlierHost, now time.Time, reason string) {
+	poolSize := int(atomic.LoadInt64(&d.poolSize))
+	ejected := 0
+	for _, host := range d.hosts {
+		if now.Before(host.ejectedUntil) {
+			ejected++
+		}
+	}
+	// at least one node may be ejected, but never the last one
+	allowed := max(1, poolSize*d.maxEjectionPercent/100)
+	if ejected >= allowed || ejected >= poolSize-1 {
+		log.Debugf("outlier node %s of %s is not ejected: %d of %d nodes are already ejected", address, d.service, ejected, poolSize)
+		return
+	}
+	h.ejections++
+	duration := min(d.baseEjectionTime*time.Duration(h.ejections), d.maxEjectionTime)
+	h.ejectedUntil = now.Add(duration)
+	h.lastEjection = now
+	h.consecutive5xx = 0
+	h.consecutiveGatewayErrors = 0
+	log.Warnf("outlier node %s of %s is ejected for %s: %s", address, d.service, duration, reason)
+	_metricOutlierEjections.WithLabelValues(d.service, address, reason).Inc()
+}
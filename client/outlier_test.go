@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+func TestOutlierDetection(t *testing.T) {
+	d := newOutlierDetector(&config.Endpoint{OutlierDetection: &config.OutlierDetection{
+		Consecutive5xx:           3,
+		ConsecutiveGatewayErrors: 2,
+		BaseEjectionTime:         10 * time.Second,
+		MaxEjectionTime:          15 * time.Second,
+		MaxEjectionPercent:       50,
+	}})
+	t.Cleanup(d.Close)
+	now := time.Unix(1700000000, 0)
+	d.now = func() time.Time { return now }
+	nodes := []selector.Node{
+		newNode("10.0.0.1:80", "HTTP", nil, nil, "", ""),
+		newNode("10.0.0.2:80", "HTTP", nil, nil, "", ""),
+		newNode("10.0.0.3:80", "HTTP", nil, nil, "", ""),
+		newNode("10.0.0.4:80", "HTTP", nil, nil, "", ""),
+	}
+	addresses := func() []string {
+		var addrs []string
+		for _, n := range d.Filter(context.Background(), nodes) {
+			addrs = append(addrs, n.Address())
+		}
+		return addrs
+	}
+	if got := addresses(); len(got) != 4 {
+		t.Fatalf("expected every node, got %v", got)
+	}
+
+	// a success resets the consecutive errors
+	d.Record("10.0.0.1:80", http.StatusInternalServerError, nil)
+	d.Record("10.0.0.1:80", http.StatusInternalServerError, nil)
+	d.Record("10.0.0.1:80", http.StatusOK, nil)
+	d.Record("10.0.0.1:80", http.StatusInternalServerError, nil)
+	d.Record("10.0.0.1:80", http.StatusInternalServerError, nil)
+	if got := addresses(); len(got) != 4 {
+		t.Fatalf("expected every node, got %v", got)
+	}
+	d.Record("10.0.0.1:80", http.StatusInternalServerError, nil)
+	if got := addresses(); len(got) != 3 || got[0] != "10.0.0.2:80" {
+		t.Fatalf("expected 10.0.0.1 to be ejected, got %v", got)
+	}
+
+	// the client canceling is not an error of the node
+	d.Record("10.0.0.2:80", 0, context.Canceled)
+	d.Record("10.0.0.2:80", 0, context.Canceled)
+	d.Record("10.0.0.2:80", 0, errors.New("connection refused"))
+	d.Record("10.0.0.2:80", http.StatusBadGateway, nil)
+	if got := addresses(); len(got) != 2 {
+		t.Fatalf("expected 10.0.0.2 to be ejected, got %v", got)
+	}
+	// no more than half of the nodes are ejected
+	d.Record("10.0.0.3:80", 0, errors.New("timeout"))
+	d.Record("10.0.0.3:80", 0, errors.New("timeout"))
+	if got := addresses(); len(got) != 2 {
+		t.Fatalf("expected the max ejection percent to be kept, got %v", got)
+	}
+
+	// the second ejection lasts longer, up to the max ejection time
+	now = now.Add(10 * time.Second)
+	if got := addresses(); len(got) != 4 {
+		t.Fatalf("expected the nodes to be back, got %v", got)
+	}
+	for i := 0; i < 3; i++ {
+		d.Record("10.0.0.1:80", http.StatusInternalServerError, nil)
+	}
+	now = now.Add(14 * time.Second)
+	if got := addresses(); len(got) != 3 {
+		t.Fatalf("expected 10.0.0.1 to be ejected for the max ejection time, got %v", got)
+	}
+	now = now.Add(time.Second)
+	if got := addresses(); len(got) != 4 {
+		t.Fatalf("expected 10.0.0.1 to be back, got %v", got)
+	}
+}
+
+func TestOutlierReload(t *testing.T) {
+	factory := NewFactory(nil)
+	endpoint := func(targets ...string) *config.Endpoint {
+		e := &config.Endpoint{
+			Protocol:         "HTTP",
+			Metadata:         map[string]string{"service": "outlier-reload"},
+			OutlierDetection: &config.OutlierDetection{Consecutive5xx: 1},
+		}
+		for _, target := range targets {
+			e.Backends = append(e.Backends, config.Backend{Target: target})
+		}
+		return e
+	}
+	newDetector := func(targets ...string) (Client, *outlierDetector) {
+		c, err := factory(endpoint(targets...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c, c.(*client).outlier
+	}
+	nodes := []selector.Node{
+		newNode("10.0.0.1:80", "HTTP", nil, nil, "", ""),
+		newNode("10.0.0.2:80", "HTTP", nil, nil, "", ""),
+	}
+
+	first, d := newDetector("10.0.0.1:80", "10.0.0.2:80")
+	d.Filter(context.Background(), nodes)
+	d.Record("10.0.0.1:80", http.StatusInternalServerError, nil)
+
+	// the reloaded client keeps the ejection of the service
+	second, d := newDetector("10.0.0.1:80", "10.0.0.2:80")
+	_ = first.Close()
+	if got := d.Filter(context.Background(), nodes); len(got) != 1 || got[0].Address() != "10.0.0.2:80" {
+		t.Fatalf("expected 10.0.0.1 to stay ejected, got %v", got)
+	}
+
+	// the hosts of the removed nodes are pruned
+	third, d := newDetector("10.0.0.2:80")
+	_ = second.Close()
+	d.lock.Lock()
+	_, ok := d.hosts["10.0.0.1:80"]
+	d.lock.Unlock()
+	if ok {
+		t.Fatal("expected the host of the removed node to be pruned")
+	}
+
+	_ = third.Close()
+	_outlierRegistry.lock.Lock()
+	defer _outlierRegistry.lock.Unlock()
+	if _, ok := _outlierRegistry.states["outlier-reload"]; ok {
+		t.Fatal("expected the state to be released with the last client")
+	}
+}
@@ -249,6 +249,10 @@ func (s *serviceWatcher) DebugHandler() http.Handler {
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(appliers)
 	})
+	debugMux.HandleFunc("/debug/watcher/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(_healthRegistry.statuses())
+	})
 	return debugMux
 }
 
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+const _defaultSlowStartMinWeightPercent = 10
+
+// slowStart tracks when the nodes joined the endpoint, across the updates
+// of the nodes. The nodes of the first update of every backend are
+// considered warm.
+type slowStart struct {
+	window    time.Duration
+	minWeight float64
+	now       func() time.Time
+
+	lock   sync.Mutex
+	warmed map[string]struct{}
+	since  map[string]time.Time
+}
+
+func newSlowStart(c *config.SlowStart) *slowStart {
+	minPercent := c.MinWeightPercent
+	if minPercent <= 0 || minPercent > 100 {
+		minPercent = _defaultSlowStartMinWeightPercent
+	}
+	return &slowStart{
+		window:    c.Window,
+		minWeight: float64(minPercent) / 100,
+		now:       time.Now,
+		warmed:    map[string]struct{}{},
+		since:     map[string]time.Time{},
+	}
+}
+
+// warm marks the nodes of the first update of a backend, they join at
+// full weight unless they are already known.
+func (s *slowStart) warm(nodes []selector.Node) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for _, n := range nodes {
+		if _, ok := s.since[n.Address()]; !ok {
+			s.warmed[n.Address()] = struct{}{}
+		}
+	}
+}
+
+// apply records the new nodes and forgets the removed ones, a node coming
+// back starts slow again.
+func (s *slowStart) apply(nodes []selector.Node) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	now := s.now()
+	since := make(map[string]time.Time, len(nodes))
+	for _, n := range nodes {
+		address := n.Address()
+		if _, ok := since[address]; ok {
+			continue
+		}
+		t, ok := s.since[address]
+		if !ok {
+			joined := now
+			if _, warm := s.warmed[address]; warm {
+				joined = time.Time{}
+			}
+			t = _slowStartRegistry.acquire(address, joined)
+		}
+		delete(s.warmed, address)
+		since[address] = t
+	}
+	for address := range s.since {
+		if _, ok := since[address]; !ok {
+			_slowStartRegistry.release(address)
+		}
+	}
+	s.since = since
+}
+
+// close releases the nodes of the endpoint.
+func (s *slowStart) close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for address := range s.since {
+		_slowStartRegistry.release(address)
+	}
+	s.since = map[string]time.Time{}
+}
+
+func (s *slowStart) joined(address string) time.Time {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.since[address]
+}
+
+// factor returns the part of its weight a node joined at since is given.
+func (s *slowStart) factor(since time.Time) float64 {
+	if since.IsZero() {
+		return 1
+	}
+	elapsed := s.now().Sub(since)
+	if elapsed >= s.window {
+		return 1
+	}
+	return s.minWeight + (1-s.minWeight)*float64(max(elapsed, 0))/float64(s.window)
+}
+
+type slowStartNode struct {
+	selector.WeightedNode
+	slowStart *slowStart
+	since     time.Time
+}
+
+func (n *slowStartNode) Weight() float64 {
+	return n.WeightedNode.Weight() * n.slowStart.factor(n.since)
+}
+
+type slowStartNodeBuilder struct {
+	selector.WeightedNodeBuilder
+	slowStart *slowStart
+}
+
+func (b *slowStartNodeBuilder) Build(n selector.Node) selector.WeightedNode {
+	return &slowStartNode{
+		WeightedNode: b.WeightedNodeBuilder.Build(n),
+		slowStart:    b.slowStart,
+		since:        b.slowStart.joined(n.Address()),
+	}
+}
+
+// slowStartSelector records the nodes before they are weighted by the selector.
+type slowStartSelector struct {
+	selector.Selector
+	slowStart *slowStart
+}
+
+func (s *slowStartSelector) Apply(nodes []selector.Node) {
+	s.slowStart.apply(nodes)
+	s.Selector.Apply(nodes)
+}
+
+func (s *slowStartSelector) warm(nodes []selector.Node) {
+	s.slowStart.warm(nodes)
+}
+
+func (s *slowStartSelector) Close() error {
+	s.slowStart.close()
+	return nil
+}
+
+type slowStartBuilder struct {
+	balancer selector.BalancerBuilder
+	node     selector.WeightedNodeBuilder
+	config   *config.SlowStart
+}
+
+func (b *slowStartBuilder) Build() selector.Selector {
+	s := newSlowStart(b.config)
+	builder := &selector.DefaultBuilder{
+		Balancer: b.balancer,
+		Node:     &slowStartNodeBuilder{WeightedNodeBuilder: b.node, slowStart: s},
+	}
+	return &slowStartSelector{Selector: builder.Build(), slowStart: s}
+}
+
+// warmer is implemented by the selectors starting the new nodes slowly, the
+// nodes of the first update of a backend are warmed before they are applied.
+type warmer interface {
+	warm(nodes []selector.Node)
+}
+
+type joinedNode struct {
+	since time.Time
+	refs  int
+}
+
+// slowStartRegistry shares when the nodes joined between the endpoints, the
+// windows are kept when the endpoints are rebuilt on reload.
+type slowStartRegistry struct {
+	lock  sync.Mutex
+	nodes map[string]*joinedNode
+}
+
+var _slowStartRegistry = &slowStartRegistry{nodes: map[string]*joinedNode{}}
+
+// acquire returns when the node joined, since if it is not known yet.
+func (r *slowStartRegistry) acquire(address string, since time.Time) time.Time {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n, ok := r.nodes[address]
+	if !ok {
+		n = &joinedNode{since: since}
+		r.nodes[address] = n
+	}
+	n.refs++
+	return n.since
+}
+
+// release forgets the node once no endpoint has it anymore.
+func (r *slowStartRegistry) release(address string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	n, ok := r.nodes[address]
+	if !ok {
+		return
+	}
+	if n.refs--; n.refs <= 0 {
+		delete(r.nodes, address)
+	}
+}
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+func TestSlowStart(t *testing.T) {
+	lb := &config.LoadBalancer{Policy: policyWeightedRoundRobin, SlowStart: &config.SlowStart{Window: time.Minute}}
+	builder, err := pickerBuilder(lb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := builder.Build().(*slowStartSelector)
+	t.Cleanup(func() { s.Close() })
+	now := time.Unix(1000, 0)
+	s.slowStart.now = func() time.Time { return now }
+
+	nodes := newTestNodes(3, 100)
+	// the nodes of the first update are warm
+	s.warm(nodes[:2])
+	s.Apply(nodes[:2])
+	s.Apply(nodes)
+	fresh := nodes[2].Address()
+	counts := map[string]int{}
+	for i := 0; i < 2100; i++ {
+		counts[pick(t, s, context.Background())]++
+	}
+	if counts[fresh] != 100 || counts[nodes[0].Address()] != 1000 {
+		t.Fatalf("expected the new node to start at 10%% of its weight, got %v", counts)
+	}
+
+	tests := []struct {
+		elapsed time.Duration
+		factor  float64
+	}{
+		{elapsed: 0, factor: 0.1},
+		{elapsed: 30 * time.Second, factor: 0.55},
+		{elapsed: time.Minute, factor: 1},
+		{elapsed: time.Hour, factor: 1},
+	}
+	since := s.slowStart.joined(fresh)
+	for _, test := range tests {
+		now = since.Add(test.elapsed)
+		if got := s.slowStart.factor(since); got != test.factor {
+			t.Errorf("after %s: expected factor %v, got %v", test.elapsed, test.factor, got)
+		}
+	}
+	if got := s.slowStart.factor(s.slowStart.joined(nodes[0].Address())); got != 1 {
+		t.Errorf("expected the warm node at full weight, got %v", got)
+	}
+
+	// the window is kept across the updates and restarts once the node left
+	now = since.Add(time.Minute)
+	s.Apply(nodes)
+	if joined := s.slowStart.joined(fresh); !joined.Equal(since) {
+		t.Fatalf("expected the node to keep joining at %s, got %s", since, joined)
+	}
+	s.Apply(nodes[:2])
+	s.Apply(nodes)
+	if joined := s.slowStart.joined(fresh); !joined.Equal(now) {
+		t.Fatalf("expected the node to join again at %s, got %s", now, joined)
+	}
+
+	builder, err = pickerBuilder(&config.LoadBalancer{Policy: policyRingHash, SlowStart: lb.SlowStart})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := builder.Build().(*slowStartSelector); ok {
+		t.Fatal("expected no slow start for the hash policies")
+	}
+}
+
+func newTestSlowStart(t *testing.T, now func() time.Time) *slowStartSelector {
+	t.Helper()
+	builder, err := pickerBuilder(&config.LoadBalancer{Policy: policyWeightedRoundRobin, SlowStart: &config.SlowStart{Window: time.Minute}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := builder.Build().(*slowStartSelector)
+	s.slowStart.now = now
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestSlowStartReload(t *testing.T) {
+	now := time.Unix(1000, 0)
+	nodes := newTestNodes(3, 100)
+	fresh := nodes[2].Address()
+	old := newTestSlowStart(t, func() time.Time { return now })
+	old.warm(nodes[:2])
+	old.Apply(nodes[:2])
+	old.Apply(nodes)
+	since := old.slowStart.joined(fresh)
+
+	// the reloaded endpoint keeps warming the node up
+	now = now.Add(10 * time.Second)
+	s := newTestSlowStart(t, func() time.Time { return now })
+	s.warm(nodes)
+	s.Apply(nodes)
+	old.Close()
+	if joined := s.slowStart.joined(fresh); !joined.Equal(since) {
+		t.Fatalf("expected the node to keep joining at %s, got %s", since, joined)
+	}
+	if joined := s.slowStart.joined(nodes[0].Address()); !joined.IsZero() {
+		t.Fatalf("expected the warm node to stay warm, got %s", joined)
+	}
+
+	// the node is forgotten once no endpoint has it
+	s.Close()
+	_slowStartRegistry.lock.Lock()
+	defer _slowStartRegistry.lock.Unlock()
+	if _, ok := _slowStartRegistry.nodes[fresh]; ok {
+		t.Fatal("expected the node to be released")
+	}
+}
+
+func TestSlowStartBackends(t *testing.T) {
+	now := time.Unix(1000, 0)
+	s := newTestSlowStart(t, func() time.Time { return now })
+	na := &nodeApplier{
+		endpoint: &config.Endpoint{},
+		picker:   s,
+		nodes:    make([][]selector.Node, 2),
+		checks:   make([]*config.HealthCheck, 2),
+		probes:   make([][]*healthProbe, 2),
+	}
+	nodes := newTestNodes(3, 100)
+	na.setNodes(0, nodes[:1])
+	// the backend discovered after the first update starts warm
+	now = now.Add(time.Second)
+	na.setNodes(1, nodes[1:2])
+	if joined := s.slowStart.joined(nodes[1].Address()); !joined.IsZero() {
+		t.Fatalf("expected the first nodes of the backend to be warm, got %s", joined)
+	}
+	na.setNodes(1, nodes[1:])
+	if joined := s.slowStart.joined(nodes[2].Address()); !joined.Equal(now) {
+		t.Fatalf("expected the new node to join at %s, got %s", now, joined)
+	}
+}
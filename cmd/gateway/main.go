@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"github.com/go-kratos/kratos/v2/config/file"
 	"net/http"
@@ -20,18 +21,27 @@ import (
 	"github.com/limes-cloud/gateway/discovery"
 	_ "github.com/limes-cloud/gateway/discovery/consul"
 	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/middleware/apikey"
 	_ "github.com/limes-cloud/gateway/middleware/auth"
 	_ "github.com/limes-cloud/gateway/middleware/bbr"
+	_ "github.com/limes-cloud/gateway/middleware/canary"
 	"github.com/limes-cloud/gateway/middleware/circuitbreaker"
+	_ "github.com/limes-cloud/gateway/middleware/concurrency"
 	_ "github.com/limes-cloud/gateway/middleware/cors"
+	_ "github.com/limes-cloud/gateway/middleware/ipfilter"
+	_ "github.com/limes-cloud/gateway/middleware/jwt"
 	_ "github.com/limes-cloud/gateway/middleware/logging"
+	"github.com/limes-cloud/gateway/middleware/mirror"
+	_ "github.com/limes-cloud/gateway/middleware/ratelimit"
 	_ "github.com/limes-cloud/gateway/middleware/rewrite"
 	_ "github.com/limes-cloud/gateway/middleware/signature"
+	_ "github.com/limes-cloud/gateway/middleware/sticky"
 	_ "github.com/limes-cloud/gateway/middleware/tracing"
 	_ "github.com/limes-cloud/gateway/middleware/transcoder"
 	"github.com/limes-cloud/gateway/proxy"
 	"github.com/limes-cloud/gateway/proxy/debug"
 	"github.com/limes-cloud/gateway/server"
+	"github.com/limes-cloud/gateway/utils/clientip"
 )
 
 func main() {
@@ -74,6 +84,8 @@ func NewServer(conf *config.Config) (transport.Server, error) {
 	}
 
 	circuitbreaker.Init(clientFactory)
+	mirror.Init(clientFactory)
+	apikey.Init(conf)
 
 	if err = pxy.Update(conf); err != nil {
 		return nil, fmt.Errorf("failed to update service conf: %v", err)
@@ -91,7 +103,19 @@ func NewServer(conf *config.Config) (transport.Server, error) {
 		handler = debug.MashupWithDebugHandler(pxy)
 	}
 
-	return server.NewProxy(handler, conf.Addr), nil
+	var opts []server.Option
+	if conf.ProxyProtocol {
+		trusted, err := clientip.ParsePrefixes(conf.TrustedProxies)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxies: %v", err)
+		}
+		// any client could spoof its address if every source was trusted
+		if len(trusted) == 0 {
+			return nil, errors.New("proxy protocol requires trusted proxies")
+		}
+		opts = append(opts, server.ProxyProtocol(trusted))
+	}
+	return server.NewProxy(handler, conf.Addr, opts...), nil
 }
 
 func makeDiscovery(dsn string) registry.Discovery {
@@ -13,6 +13,12 @@ type Config struct {
 	Discovery   string
 	Endpoints   []Endpoint
 	Middlewares []Middleware
+	APIKeys     []APIKey
+	// TrustedProxies are the CIDRs of the proxies whose forwarding headers are trusted.
+	TrustedProxies []string
+	// ProxyProtocol reads the PROXY protocol header of the connections from the
+	// trusted proxies, which are then required to send one.
+	ProxyProtocol bool
 }
 
 type Watch func(*Config)
@@ -42,3 +48,16 @@ func (c *Config) WatchEndpoints(fn Watch) {
 		fn(c)
 	})
 }
+
+// WatchAPIKeys 监听API Key
+func (c *Config) WatchAPIKeys(fn func([]APIKey)) {
+	c.conf.Watch("apiKeys", func(value config.Value) {
+		var keys []APIKey
+		if err := value.Scan(&keys); err != nil {
+			log.Error("watch api keys change error:" + err.Error())
+			return
+		}
+		c.APIKeys = keys
+		fn(keys)
+	})
+}
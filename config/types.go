@@ -3,17 +3,45 @@ package config
 import "time"
 
 type Endpoint struct {
-	Path           string
-	Method         string
-	Description    string
-	Protocol       string
-	ResponseFormat bool
-	Timeout        time.Duration
-	Metadata       map[string]string
-	Host           string
-	Middlewares    []Middleware
-	Backends       []Backend
-	Retry          *Retry
+	Path             string
+	Method           string
+	Description      string
+	Protocol         string
+	ResponseFormat   bool
+	Timeout          time.Duration
+	MaxBodySize      int64
+	Metadata         map[string]string
+	Host             string
+	Match            *Match
+	Priority         int
+	Middlewares      []Middleware
+	Backends         []Backend
+	Retry            *Retry
+	ErrorResponses   []ErrorResponse
+	HealthCheck      *HealthCheck
+	OutlierDetection *OutlierDetection
+	LoadBalancer     *LoadBalancer
+}
+
+type ErrorResponse struct {
+	StatusCode int
+	Reason     string
+	Message    string
+}
+
+type Match struct {
+	Headers []MatchRule
+	Queries []MatchRule
+	Cookies []MatchRule
+}
+
+type MatchRule struct {
+	Name    string
+	Value   string
+	Prefix  string
+	Regex   string
+	Present bool
+	Absent  bool
 }
 
 type Middleware struct {
@@ -23,8 +51,62 @@ type Middleware struct {
 }
 
 type Backend struct {
-	Target string
-	Weight *int64
+	Target      string
+	Weight      *int64
+	Version     string
+	Metadata    map[string]string
+	HealthCheck *HealthCheck
+}
+
+type LoadBalancer struct {
+	// Policy is round_robin, weighted_round_robin, random, least_request, p2c, ring_hash or maglev.
+	Policy string
+	// HashOn is the key of the hash policies: header:<name>, cookie:<name>, query:<name> or ip.
+	HashOn string
+	// VirtualNodes is the number of points of the heaviest node on the ring of the ring_hash policy, the other nodes get points in proportion to their weight.
+	VirtualNodes int
+	// TableSize is the prime size of the lookup table of the maglev policy.
+	TableSize int
+	// SlowStart ramps up the weight of the new nodes, the hash and the
+	// round_robin and random policies ignore the weights.
+	SlowStart *SlowStart
+}
+
+type SlowStart struct {
+	// Window is the duration for a new node to reach its full weight.
+	Window time.Duration
+	// MinWeightPercent is the percent of its weight a new node starts with.
+	MinWeightPercent int
+}
+
+type OutlierDetection struct {
+	Consecutive5xx           int
+	ConsecutiveGatewayErrors int
+	// BaseEjectionTime is multiplied by the number of times the node has been ejected.
+	BaseEjectionTime   time.Duration
+	MaxEjectionTime    time.Duration
+	MaxEjectionPercent int
+}
+
+type HealthCheck struct {
+	// Type is http, grpc or tcp, it defaults to the protocol of the endpoint.
+	Type string
+	Path string
+	Host string
+	// ExpectedStatuses are the healthy http status codes, 2xx by default.
+	ExpectedStatuses []int
+	// Service is the service checked with the grpc health protocol, the whole server by default.
+	Service            string
+	Interval           time.Duration
+	Timeout            time.Duration
+	HealthyThreshold   int
+	UnhealthyThreshold int
+}
+
+type APIKey struct {
+	Key      string
+	Consumer string
+	Metadata map[string]string
 }
 
 type Header struct {
@@ -33,14 +115,37 @@ type Header struct {
 }
 
 type Condition struct {
-	Header     *Header
-	StatusCode string
+	Header         *Header
+	StatusCode     string
+	TransportError string
 }
 
 type Retry struct {
-	Count      int
-	Timeout    time.Duration
-	Conditions []Condition
+	Count             int
+	Timeout           time.Duration
+	Conditions        []Condition
+	Backoff           *Backoff
+	Budget            *RetryBudget
+	Hedging           *Hedging
+	IdempotentMethods []string
+	IdempotencyHeader string
+}
+
+type Hedging struct {
+	Delay time.Duration
+}
+
+type RetryBudget struct {
+	Ratio               float64
+	Window              time.Duration
+	MinRetriesPerSecond int
+}
+
+type Backoff struct {
+	BaseInterval time.Duration
+	MaxInterval  time.Duration
+	Multiplier   float64
+	Jitter       float64
 }
 
 type Tracing struct {
@@ -76,6 +181,34 @@ type CircuitBreaker struct {
 	Conditions []Condition
 }
 
+type CanaryGroup struct {
+	Name     string
+	Version  string
+	Metadata map[string]string
+	Weight   int
+}
+
+type CanaryRule struct {
+	Header *Header
+	Cookie *Header
+	Group  string
+}
+
+type Canary struct {
+	Groups []CanaryGroup
+	Rules  []CanaryRule
+	HashOn string
+}
+
+type Mirror struct {
+	Target         string
+	Protocol       string
+	Percentage     float64
+	MaxConcurrency int
+	MaxBodySize    int64
+	Timeout        time.Duration
+}
+
 type Cors struct {
 	AllowCredentials    bool
 	AllowOrigins        []string
@@ -0,0 +1,178 @@
+package apikey
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+const (
+	storeInline = "inline"
+	storeFile   = "file"
+	storeConfig = "config"
+
+	defaultHeader               = "X-API-Key"
+	defaultConsumerHeader       = "X-Consumer"
+	defaultMetadataHeaderPrefix = "X-Consumer-"
+)
+
+// Init registers the apikey middleware, the keys of the `config`
+// store are read from the apiKeys of the gateway config and follow its changes.
+func Init(conf *config.Config) {
+	_configStore.update(conf.APIKeys)
+	conf.WatchAPIKeys(_configStore.update)
+	middleware.RegisterV2("apikey", Middleware)
+	prometheus.MustRegister(_metricConsumerRequestsTotal)
+}
+
+var _metricConsumerRequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "go",
+	Subsystem: "gateway",
+	Name:      "requests_consumer_total",
+	Help:      "The total number of processed requests by consumer",
+}, []string{"protocol", "method", "path", "code", "service", "basePath", "consumer"})
+
+func consumerRequestsIncr(req *http.Request, consumer string, code int) {
+	labels, ok := middleware.MetricsLabelsFromContext(req.Context())
+	if !ok {
+		return
+	}
+	_metricConsumerRequestsTotal.WithLabelValues(labels.Protocol(), labels.Method(), labels.Path(), strconv.Itoa(code), labels.Service(), labels.BasePath(), consumer).Inc()
+}
+
+type APIKey struct {
+	Header               string
+	Query                string
+	Basic                bool
+	Store                string
+	Keys                 []config.APIKey
+	File                 string
+	PollInterval         time.Duration
+	ConsumerHeader       string
+	MetadataHeaderPrefix string
+	KeepCredential       bool
+}
+
+func newStore(options *APIKey) (Store, io.Closer, error) {
+	switch options.Store {
+	case "", storeInline:
+		return newMemoryStore(options.Keys), io.NopCloser(nil), nil
+	case storeFile:
+		s, err := newFileStore(options.File, options.PollInterval)
+		if err != nil {
+			return nil, nil, err
+		}
+		return s, s, nil
+	case storeConfig:
+		return _configStore, io.NopCloser(nil), nil
+	}
+	return nil, nil, fmt.Errorf("unknown api key store: %s", options.Store)
+}
+
+// extract returns the key of the request and removes it unless the credential is kept.
+func (o *APIKey) extract(req *http.Request) string {
+	if o.Header != "" {
+		if key := req.Header.Get(o.Header); key != "" {
+			if !o.KeepCredential {
+				req.Header.Del(o.Header)
+			}
+			return key
+		}
+	}
+	if o.Query != "" {
+		query := req.URL.Query()
+		if key := query.Get(o.Query); key != "" {
+			if !o.KeepCredential {
+				query.Del(o.Query)
+				req.URL.RawQuery = query.Encode()
+			}
+			return key
+		}
+	}
+	if o.Basic {
+		if user, password, ok := req.BasicAuth(); ok {
+			if !o.KeepCredential {
+				req.Header.Del("Authorization")
+			}
+			if password != "" {
+				return password
+			}
+			return user
+		}
+	}
+	return ""
+}
+
+// removeIdentity drops the consumer headers sent by the client.
+func (o *APIKey) removeIdentity(header http.Header) {
+	header.Del(o.ConsumerHeader)
+	for k := range header {
+		if strings.HasPrefix(k, o.MetadataHeaderPrefix) {
+			delete(header, k)
+		}
+	}
+}
+
+// Middleware authenticates the consumer of the request by its API key,
+// the consumer and its metadata are forwarded to the upstream as headers.
+func Middleware(c *config.Middleware) (middleware.MiddlewareV2, error) {
+	options := &APIKey{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	if options.Header == "" && options.Query == "" && !options.Basic {
+		options.Header = defaultHeader
+	}
+	if options.ConsumerHeader == "" {
+		options.ConsumerHeader = defaultConsumerHeader
+	}
+	if options.MetadataHeaderPrefix == "" {
+		options.MetadataHeaderPrefix = defaultMetadataHeaderPrefix
+	}
+	options.MetadataHeaderPrefix = http.CanonicalHeaderKey(options.MetadataHeaderPrefix)
+	store, closer, err := newStore(options)
+	if err != nil {
+		return nil, err
+	}
+	return middleware.NewWithCloser(func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			options.removeIdentity(req.Header)
+			key := options.extract(req)
+			if key == "" {
+				return render.NewResponse(req, render.NewError(http.StatusUnauthorized, "missing api key")), nil
+			}
+			consumer, ok := store.Get(key)
+			if !ok {
+				return render.NewResponse(req, render.NewError(http.StatusUnauthorized, "invalid api key")), nil
+			}
+
+			req.Header.Set(options.ConsumerHeader, consumer.Consumer)
+			for k, v := range consumer.Metadata {
+				req.Header.Set(options.MetadataHeaderPrefix+k, v)
+			}
+			if reqOpt, ok := middleware.FromRequestContext(req.Context()); ok {
+				reqOpt.Metadata["consumer"] = consumer.Consumer
+			}
+
+			resp, err := next.RoundTrip(req)
+			code := http.StatusBadGateway
+			if err == nil {
+				code = resp.StatusCode
+			}
+			consumerRequestsIncr(req, consumer.Consumer, code)
+			return resp, err
+		})
+	}, closer), nil
+}
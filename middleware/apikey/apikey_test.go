@@ -0,0 +1,118 @@
+package apikey
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+func newTestMiddleware(t *testing.T, options map[string]any) http.RoundTripper {
+	m, err := Middleware(&config.Middleware{Name: "apikey", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m.Process(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		for k, v := range req.Header {
+			w.Header()["Upstream-"+k] = v
+		}
+		w.Header().Set("Upstream-Query", req.URL.RawQuery)
+		return w.Result(), nil
+	}))
+}
+
+func TestMiddleware(t *testing.T) {
+	tripper := newTestMiddleware(t, map[string]any{
+		"query": "api_key",
+		"basic": true,
+		"keys": []map[string]any{
+			{"key": "k-partner", "consumer": "partner", "metadata": map[string]string{"tier": "gold"}},
+		},
+	})
+	tests := []struct {
+		name     string
+		prepare  func(req *http.Request)
+		status   int
+		consumer string
+	}{
+		{"missing", func(req *http.Request) {}, http.StatusUnauthorized, ""},
+		{"invalid", func(req *http.Request) { req.URL.RawQuery = "api_key=other" }, http.StatusUnauthorized, ""},
+		{"query", func(req *http.Request) { req.URL.RawQuery = "api_key=k-partner&page=1" }, http.StatusOK, "partner"},
+		{"basic", func(req *http.Request) { req.SetBasicAuth("k-partner", "") }, http.StatusOK, "partner"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/orders", nil)
+			req.Header.Set("X-Consumer", "spoofed")
+			req.Header.Set("X-Consumer-Tier", "spoofed")
+			tt.prepare(req)
+			resp, err := tripper.RoundTrip(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status != http.StatusOK {
+				return
+			}
+			if got := resp.Header.Get("Upstream-X-Consumer"); got != tt.consumer {
+				t.Fatalf("consumer = %q, want %q", got, tt.consumer)
+			}
+			if got := resp.Header.Get("Upstream-X-Consumer-Tier"); got != "gold" {
+				t.Fatalf("tier = %q", got)
+			}
+			if resp.Header.Get("Upstream-Authorization") != "" || strings.Contains(resp.Header.Get("Upstream-Query"), "api_key") {
+				t.Fatalf("credential forwarded upstream: %v", resp.Header)
+			}
+		})
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.yaml")
+	write := func(content string) {
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("- key: k1\n  consumer: alice\n")
+	s, err := newFileStore(path, 10*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if v, ok := s.Get("k1"); !ok || v.Consumer != "alice" {
+		t.Fatalf("k1 = %+v, %v", v, ok)
+	}
+
+	write(`[{"key": "k2", "consumer": "bob"}]`)
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, ok := s.Get("k2"); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("file change not reloaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := s.Get("k1"); ok {
+		t.Fatal("removed key still valid")
+	}
+
+	// a broken file keeps the keys loaded last
+	write("- key: [")
+	time.Sleep(50 * time.Millisecond)
+	if _, ok := s.Get("k2"); !ok {
+		t.Fatal("keys dropped on invalid file")
+	}
+}
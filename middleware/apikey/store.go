@@ -0,0 +1,147 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"gopkg.in/yaml.v3"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+const _defaultPollInterval = 10 * time.Second
+
+// Store looks up the consumer of an API key.
+type Store interface {
+	Get(key string) (*config.APIKey, bool)
+}
+
+// memoryStore indexes the keys by their hash, so that the
+// lookup does not compare the secrets byte by byte.
+type memoryStore struct {
+	keys atomic.Pointer[map[[sha256.Size]byte]*config.APIKey]
+}
+
+func newMemoryStore(keys []config.APIKey) *memoryStore {
+	s := &memoryStore{}
+	s.update(keys)
+	return s
+}
+
+func (s *memoryStore) update(keys []config.APIKey) {
+	index := make(map[[sha256.Size]byte]*config.APIKey, len(keys))
+	for i := range keys {
+		key := keys[i]
+		if key.Key == "" {
+			log.Warnf("Skip api key of consumer %s: empty key", key.Consumer)
+			continue
+		}
+		index[sha256.Sum256([]byte(key.Key))] = &key
+	}
+	s.keys.Store(&index)
+}
+
+func (s *memoryStore) Get(key string) (*config.APIKey, bool) {
+	index := s.keys.Load()
+	if index == nil {
+		return nil, false
+	}
+	v, ok := (*index)[sha256.Sum256([]byte(key))]
+	return v, ok
+}
+
+// _configStore holds the keys pushed through the config source, see Init.
+var _configStore = newMemoryStore(nil)
+
+// fileStore reloads the keys of a YAML or JSON file whenever it changes.
+type fileStore struct {
+	*memoryStore
+	path      string
+	modTime   time.Time
+	size      int64
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func loadKeys(path string) ([]config.APIKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keys []config.APIKey
+	if err := yaml.Unmarshal(b, &keys); err != nil {
+		return nil, fmt.Errorf("invalid api key file %s: %w", path, err)
+	}
+	return keys, nil
+}
+
+func newFileStore(path string, interval time.Duration) (*fileStore, error) {
+	if path == "" {
+		return nil, errors.New("api key file is required")
+	}
+	if interval <= 0 {
+		interval = _defaultPollInterval
+	}
+	s := &fileStore{
+		memoryStore: newMemoryStore(nil),
+		path:        path,
+		done:        make(chan struct{}),
+	}
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+	go s.watch(interval)
+	return s, nil
+}
+
+// reload loads the file if it changed since the last load.
+func (s *fileStore) reload() (bool, error) {
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return false, err
+	}
+	if info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return false, nil
+	}
+	keys, err := loadKeys(s.path)
+	if err != nil {
+		return false, err
+	}
+	s.update(keys)
+	s.modTime, s.size = info.ModTime(), info.Size()
+	return true, nil
+}
+
+func (s *fileStore) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			reloaded, err := s.reload()
+			if err != nil {
+				// keep serving the keys loaded last
+				log.Errorf("Failed to reload api keys from %s: %+v", s.path, err)
+				continue
+			}
+			if reloaded {
+				log.Infof("Reloaded api keys from %s", s.path)
+			}
+		}
+	}
+}
+
+func (s *fileStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
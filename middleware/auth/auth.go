@@ -3,50 +3,71 @@ package auth
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
-	"regexp"
+	"strconv"
 	"strings"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
 
 	"github.com/limes-cloud/gateway/config"
 	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
 	"github.com/limes-cloud/gateway/utils"
+	"github.com/limes-cloud/gateway/utils/pathmatch"
 )
 
 func init() {
 	middleware.Register("auth", Middleware)
 }
 
-type Auth struct {
-	URL         string
-	Method      string
-	ContentType string
-	Whitelist   []struct {
-		Path   string
-		Method string
-	}
-}
-
-func (as *Auth) isWhitelist(method, path string) bool {
-	for _, item := range as.Whitelist {
-		if method != item.Method {
-			continue
-		}
+const (
+	_defaultTimeout = 3 * time.Second
+	// _maxDenyBodySize limits the denied response body kept in cache.
+	_maxDenyBodySize = 64 << 10
+)
 
-		// 将*替换为匹配任意多字符的正则表达式
-		pattern := "^" + item.Path + "$"
-		pattern = regexp.MustCompile(`/\*`).ReplaceAllString(pattern, "/.+")
+// _transport is shared by the auth middlewares to reuse connections to the auth services.
+var _transport = func() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConns = 1000
+	transport.MaxIdleConnsPerHost = 100
+	return transport
+}()
+
+type Cache struct {
+	TTL     time.Duration
+	DenyTTL time.Duration
+	Size    int
+}
 
-		// 编译正则表达式
-		re := regexp.MustCompile(pattern)
+type Auth struct {
+	URL            string
+	Method         string
+	ContentType    string
+	Timeout        time.Duration
+	TokenHeader    string
+	Cache          *Cache
+	ForwardHeaders []string
+	Whitelist      []pathmatch.Rule
+
+	whitelist *pathmatch.Matcher
+}
 
-		// 检查输入是否匹配正则表达式
-		if re.MatchString(path) {
-			return true
-		}
+// compile builds the whitelist matcher once, at middleware construction.
+func (as *Auth) compile() error {
+	whitelist, err := pathmatch.New(as.Whitelist)
+	if err != nil {
+		return err
 	}
+	as.whitelist = whitelist
+	return nil
+}
 
-	return false
+func (as *Auth) isWhitelist(method, host, path string) bool {
+	return as.whitelist.Match(method, host, path)
 }
 
 type RequestInfo struct {
@@ -55,7 +76,74 @@ type RequestInfo struct {
 	Data   any    `json:"data"`
 }
 
-var _nopBody = io.NopCloser(&bytes.Buffer{})
+// check asks the auth service whether the request is allowed.
+func (as *Auth) check(client *http.Client, req *http.Request) (*decision, error) {
+	var data any
+	if req.Method == http.MethodGet || req.Method == http.MethodDelete {
+		data = req.URL.Query()
+	} else if strings.Contains(req.Header.Get("Content-Type"), "json") {
+		dataBody, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewBuffer(dataBody))
+		_ = json.Unmarshal(dataBody, &data)
+	}
+
+	body := RequestInfo{
+		Path:   req.URL.Path,
+		Method: req.Method,
+		Data:   data,
+	}
+	byteBody, _ := json.Marshal(body)
+	request, err := http.NewRequestWithContext(req.Context(), as.Method, as.URL, bytes.NewReader(byteBody))
+	if err != nil {
+		return nil, err
+	}
+	request.Header = req.Header.Clone()
+	request.Header.Add("Content-Type", as.ContentType)
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		respBody, err := io.ReadAll(io.LimitReader(response.Body, _maxDenyBodySize))
+		if err != nil {
+			return nil, err
+		}
+		header := http.Header{}
+		if contentType := response.Header.Get("Content-Type"); contentType != "" {
+			header.Set("Content-Type", contentType)
+		}
+		return &decision{statusCode: response.StatusCode, header: header, body: respBody}, nil
+	}
+	_, _ = io.Copy(io.Discard, response.Body)
+	header := http.Header{}
+	for _, key := range as.ForwardHeaders {
+		if values := response.Header.Values(key); len(values) > 0 {
+			header[http.CanonicalHeaderKey(key)] = values
+		}
+	}
+	return &decision{allow: true, statusCode: response.StatusCode, header: header}, nil
+}
+
+// cacheable reports whether the decision can be reused, failures of the auth service are not cached.
+func (d *decision) cacheable() bool {
+	return d.allow || d.statusCode < http.StatusInternalServerError
+}
+
+func (d *decision) response(req *http.Request) *http.Response {
+	header := d.header.Clone()
+	header.Set("Content-Length", strconv.Itoa(len(d.body)))
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", d.statusCode, http.StatusText(d.statusCode)),
+		StatusCode:    d.statusCode,
+		Header:        header,
+		ContentLength: int64(len(d.body)),
+		Body:          io.NopCloser(bytes.NewReader(d.body)),
+		Request:       req,
+	}
+}
 
 func Middleware(c *config.Middleware) (middleware.Middleware, error) {
 	auth := &Auth{}
@@ -67,64 +155,61 @@ func Middleware(c *config.Middleware) (middleware.Middleware, error) {
 			auth.ContentType = "application/json;charset=utf8"
 		}
 	}
+	if err := auth.compile(); err != nil {
+		return nil, err
+	}
+	if auth.Timeout <= 0 {
+		auth.Timeout = _defaultTimeout
+	}
+	if auth.TokenHeader == "" {
+		auth.TokenHeader = "Authorization"
+	}
+	client := &http.Client{Transport: _transport, Timeout: auth.Timeout}
+	var cache *decisionCache
+	if auth.Cache != nil && auth.Cache.TTL > 0 {
+		cache = newDecisionCache(auth.Cache)
+	}
 
 	return func(next http.RoundTripper) http.RoundTripper {
 		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
-			if auth.isWhitelist(req.Method, req.URL.Path) {
+			if auth.isWhitelist(req.Method, req.Host, req.URL.Path) {
 				return next.RoundTrip(req)
 			}
-
-			var data any
-			if req.Method == http.MethodGet || req.Method == http.MethodDelete {
-				data = req.URL.Query()
-			} else if strings.Contains(req.Header.Get("Content-Type"), "json") {
-				dataBody, _ := io.ReadAll(req.Body)
-				req.Body = io.NopCloser(bytes.NewBuffer(dataBody))
-				_ = json.Unmarshal(dataBody, &data)
+			// never trust the identity headers sent by the client
+			for _, key := range auth.ForwardHeaders {
+				req.Header.Del(key)
 			}
 
-			body := RequestInfo{
-				Path:   req.URL.Path,
-				Method: req.Method,
-				Data:   data,
+			var (
+				result *decision
+				hit    bool
+				key    cacheKey
+			)
+			// the requests without token are identified by other credentials
+			// such as cookies, their decisions are never shared.
+			token := req.Header.Get(auth.TokenHeader)
+			cached := cache != nil && token != ""
+			if cached {
+				key = newCacheKey(token, req.Method, req.URL.Path)
+				result, hit = cache.Get(key)
 			}
-			byteBody, _ := json.Marshal(body)
-			request, err := http.NewRequest(auth.Method, auth.URL, bytes.NewReader(byteBody))
-			//
-			// jsonHeader := http.Header{}
-			// jsonHeader.Add("Content-Type", auth.ContentType)
-			if err != nil {
-				return &http.Response{
-					Status:     http.StatusText(http.StatusUnauthorized),
-					StatusCode: http.StatusUnauthorized,
-					Body:       _nopBody,
-					// Header:     jsonHeader,
-				}, nil
+			if !hit {
+				var err error
+				if result, err = auth.check(client, req); err != nil {
+					log.Errorf("Failed to request auth service: %s: %+v", auth.URL, err)
+					return render.NewResponse(req, render.NewError(http.StatusUnauthorized, "")), nil
+				}
+				if cached && result.cacheable() {
+					cache.Set(key, result)
+				}
 			}
 
-			request.Header = req.Header.Clone()
-			request.Header.Add("Content-Type", auth.ContentType)
-
-			client := http.Client{}
-			response, err := client.Do(request)
-			if err != nil {
-				return &http.Response{
-					Status:     http.StatusText(http.StatusUnauthorized),
-					StatusCode: http.StatusUnauthorized,
-					Body:       _nopBody,
-					// Header:     jsonHeader,
-				}, nil
+			if !result.allow {
+				return result.response(req), nil
 			}
-
-			if response.StatusCode != http.StatusOK {
-				return &http.Response{
-					Status:     http.StatusText(response.StatusCode),
-					StatusCode: response.StatusCode,
-					Body:       response.Body,
-					// Header:     jsonHeader,
-				}, nil
+			for k, v := range result.header {
+				req.Header[k] = append([]string(nil), v...)
 			}
-
 			return next.RoundTrip(req)
 		})
 	}, nil
@@ -1,12 +1,20 @@
 package auth
 
-import "testing"
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/utils/pathmatch"
+)
 
 func TestMiddleware(t *testing.T) {
-	type item = struct {
-		Path   string
-		Method string
-	}
+	type item = pathmatch.Rule
 
 	object := Auth{
 		Whitelist: []item{
@@ -55,9 +63,148 @@ func TestMiddleware(t *testing.T) {
 		},
 	}
 
+	if err := object.compile(); err != nil {
+		t.Fatal(err)
+	}
 	for _, item := range tests {
-		if object.isWhitelist(item.input.Method, item.input.Path) != item.result {
+		if object.isWhitelist(item.input.Method, "", item.input.Path) != item.result {
 			t.Error("result error " + item.input.Path)
 		}
 	}
 }
+
+func TestDecisionCache(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		switch r.Header.Get("Authorization") {
+		case "Bearer alice":
+			w.Header().Set("X-User-Id", "1")
+			w.Header().Set("X-Tenant", "acme")
+			w.Header().Set("X-Internal", "secret")
+		case "Bearer broken":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"code":401,"reason":"UNAUTHORIZED"}`))
+		}
+	}))
+	defer server.Close()
+
+	m, err := Middleware(&config.Middleware{Options: map[string]any{
+		"url":            server.URL,
+		"method":         http.MethodPost,
+		"cache":          map[string]any{"ttl": "100ms", "size": 2},
+		"forwardHeaders": "X-User-Id,X-Tenant",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tripper := m(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		w.Header().Set("X-User-Id", req.Header.Get("X-User-Id"))
+		w.Header().Set("X-Tenant", req.Header.Get("X-Tenant"))
+		w.Header().Set("X-Internal", req.Header.Get("X-Internal"))
+		return w.Result(), nil
+	}))
+	do := func(token, path string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("X-User-Id", "spoofed")
+		resp, err := tripper.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	for i := 0; i < 3; i++ {
+		resp := do("alice", "/users")
+		if resp.StatusCode != http.StatusOK || resp.Header.Get("X-User-Id") != "1" || resp.Header.Get("X-Tenant") != "acme" {
+			t.Fatalf("unexpected allowed response: %d %v", resp.StatusCode, resp.Header)
+		}
+		if resp.Header.Get("X-Internal") != "" {
+			t.Fatal("header not listed in forwardHeaders is forwarded")
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Fatalf("auth service called %d times, want 1", n)
+	}
+
+	for i := 0; i < 2; i++ {
+		resp := do("mallory", "/users")
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusUnauthorized || resp.Status != "401 Unauthorized" || string(body) != `{"code":401,"reason":"UNAUTHORIZED"}` {
+			t.Fatalf("unexpected denied response: %s %s", resp.Status, body)
+		}
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("auth service called %d times, want 2", n)
+	}
+
+	// failures of the auth service are not cached
+	do("broken", "/users")
+	do("broken", "/users")
+	if n := atomic.LoadInt32(&calls); n != 4 {
+		t.Fatalf("auth service called %d times, want 4", n)
+	}
+
+	// decisions expire and are evicted by size
+	time.Sleep(150 * time.Millisecond)
+	do("alice", "/users")
+	do("alice", "/orders")
+	do("alice", "/items")
+	do("alice", "/users")
+	if n := atomic.LoadInt32(&calls); n != 8 {
+		t.Fatalf("auth service called %d times, want 8", n)
+	}
+}
+
+func TestDecisionCacheWithoutToken(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		cookie, err := r.Cookie("session")
+		if err != nil || cookie.Value != "alice" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("X-User-Id", "1")
+	}))
+	defer server.Close()
+
+	m, err := Middleware(&config.Middleware{Options: map[string]any{
+		"url":            server.URL,
+		"method":         http.MethodPost,
+		"cache":          map[string]any{"ttl": "1m"},
+		"forwardHeaders": "X-User-Id",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tripper := m(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		w.Header().Set("X-User-Id", req.Header.Get("X-User-Id"))
+		return w.Result(), nil
+	}))
+	do := func(session string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/users", nil)
+		req.AddCookie(&http.Cookie{Name: "session", Value: session})
+		resp, err := tripper.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	if resp := do("alice"); resp.StatusCode != http.StatusOK || resp.Header.Get("X-User-Id") != "1" {
+		t.Fatalf("unexpected allowed response: %d %v", resp.StatusCode, resp.Header)
+	}
+	// another client without token must not get the decision of the first one
+	if resp := do("mallory"); resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("X-User-Id") != "" {
+		t.Fatalf("unexpected response of another client: %d %v", resp.StatusCode, resp.Header)
+	}
+	if n := atomic.LoadInt32(&calls); n != 2 {
+		t.Fatalf("auth service called %d times, want 2", n)
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const _defaultCacheSize = 10000
+
+type cacheKey [sha256.Size]byte
+
+func newCacheKey(token, method, path string) cacheKey {
+	h := sha256.New()
+	h.Write([]byte(token))
+	h.Write([]byte{0})
+	h.Write([]byte(method))
+	h.Write([]byte{0})
+	h.Write([]byte(path))
+	var key cacheKey
+	h.Sum(key[:0])
+	return key
+}
+
+// decision is the answer of the auth service for a request.
+type decision struct {
+	allow      bool
+	statusCode int
+	header     http.Header
+	body       []byte
+}
+
+type cacheEntry struct {
+	key      cacheKey
+	value    *decision
+	expireAt time.Time
+}
+
+// decisionCache is a LRU cache of decisions with expiration.
+type decisionCache struct {
+	lock    sync.Mutex
+	size    int
+	ttl     time.Duration
+	denyTTL time.Duration
+	items   map[cacheKey]*list.Element
+	order   *list.List
+}
+
+func newDecisionCache(options *Cache) *decisionCache {
+	size := options.Size
+	if size <= 0 {
+		size = _defaultCacheSize
+	}
+	denyTTL := options.DenyTTL
+	if denyTTL <= 0 {
+		denyTTL = options.TTL
+	}
+	return &decisionCache{
+		size:    size,
+		ttl:     options.TTL,
+		denyTTL: denyTTL,
+		items:   make(map[cacheKey]*list.Element, size),
+		order:   list.New(),
+	}
+}
+
+func (c *decisionCache) Get(key cacheKey) (*decision, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*cacheEntry)
+	if time.Now().After(entry.expireAt) {
+		c.order.Remove(elem)
+		delete(c.items, key)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *decisionCache) Set(key cacheKey, value *decision) {
+	ttl := c.ttl
+	if !value.allow {
+		ttl = c.denyTTL
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.value = value
+		entry.expireAt = time.Now().Add(ttl)
+		c.order.MoveToFront(elem)
+		return
+	}
+	c.items[key] = c.order.PushFront(&cacheEntry{key: key, value: value, expireAt: time.Now().Add(ttl)})
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.items, oldest.Value.(*cacheEntry).key)
+	}
+}
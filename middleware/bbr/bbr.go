@@ -1,35 +1,199 @@
 package bbr
 
 import (
-	"bytes"
-	"github.com/limes-cloud/gateway/config"
-	"io"
+	"errors"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-kratos/aegis/ratelimit"
 	"github.com/go-kratos/aegis/ratelimit/bbr"
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/limes-cloud/gateway/config"
 	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
+	"github.com/limes-cloud/gateway/utils"
 )
 
-var _nopBody = io.NopCloser(&bytes.Buffer{})
+const (
+	_defaultWindow = 10 * time.Second
+	_defaultBucket = 100
+)
 
 func init() {
 	middleware.Register("bbr", Middleware)
+	prometheus.MustRegister(_metricInflight, _metricMaxPass, _metricMaxInflight, _metricDroppedTotal)
+}
+
+var (
+	_metricLabels = []string{"protocol", "method", "path", "service", "basePath", "scope"}
+
+	_metricInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "bbr_inflight",
+		Help:      "The number of in-flight requests of the bbr limiter",
+	}, _metricLabels)
+	_metricMaxPass = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "bbr_max_pass",
+		Help:      "The max passed requests per bucket of the bbr limiter",
+	}, _metricLabels)
+	_metricMaxInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "bbr_max_inflight",
+		Help:      "The estimated max in-flight requests of the bbr limiter",
+	}, _metricLabels)
+	_metricDroppedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "bbr_dropped_total",
+		Help:      "The total number of requests dropped by the bbr limiter",
+	}, _metricLabels)
+)
+
+type BBR struct {
+	// Window is the duration of the statistics window, 10s by default.
+	Window time.Duration
+	// Bucket is the number of buckets of the window, 100 by default.
+	Bucket int
+	// CPUThreshold is the cpu usage in per mille above which requests are shed, 800 by default.
+	CPUThreshold int64
+	// CPUQuota is the number of cpus of the container, when it can not be read from the process.
+	CPUQuota float64
+	// Scope names a limiter shared by every endpoint using the same scope,
+	// each endpoint has its own limiter when it is empty.
+	Scope string
+}
+
+func (o *BBR) limiterOptions() ([]bbr.Option, error) {
+	var opts []bbr.Option
+	window, bucket := o.Window, o.Bucket
+	if window < 0 || bucket < 0 || o.CPUThreshold < 0 || o.CPUQuota < 0 {
+		return nil, errors.New("bbr options must not be negative")
+	}
+	if window > 0 {
+		opts = append(opts, bbr.WithWindow(window))
+	}
+	if bucket > 0 {
+		opts = append(opts, bbr.WithBucket(bucket))
+	}
+	if d := o.bucketDuration(); d <= 0 || d > time.Second {
+		return nil, errors.New("bbr bucket duration must be within (0, 1s]")
+	}
+	if o.CPUThreshold > 0 {
+		opts = append(opts, bbr.WithCPUThreshold(o.CPUThreshold))
+	}
+	if o.CPUQuota > 0 {
+		opts = append(opts, bbr.WithCPUQuota(o.CPUQuota))
+	}
+	return opts, nil
+}
+
+// bucketDuration returns the duration of a bucket of the window.
+func (o *BBR) bucketDuration() time.Duration {
+	window, bucket := o.Window, o.Bucket
+	if window <= 0 {
+		window = _defaultWindow
+	}
+	if bucket <= 0 {
+		bucket = _defaultBucket
+	}
+	return window / time.Duration(bucket)
+}
+
+type scopedLimiter struct {
+	options BBR
+	limiter *bbr.BBR
+}
+
+var (
+	_scopesLock sync.Mutex
+	_scopes     = map[string]*scopedLimiter{}
+)
+
+// scopeLimiter returns the limiter of the scope, it is kept across config
+// reloads unless the options of the scope change.
+func scopeLimiter(options *BBR, opts []bbr.Option) *bbr.BBR {
+	_scopesLock.Lock()
+	defer _scopesLock.Unlock()
+	if s, ok := _scopes[options.Scope]; ok {
+		if s.options == *options {
+			return s.limiter
+		}
+		log.Warnf("bbr scope %s is configured with different options, the last ones are used", options.Scope)
+	}
+	s := &scopedLimiter{options: *options, limiter: bbr.NewLimiter(opts...)}
+	_scopes[options.Scope] = s
+	return s.limiter
+}
+
+// gaugeSampler refreshes the gauges of a limiter at most once per bucket, the
+// stats of the limiter walk its whole window.
+type gaugeSampler struct {
+	interval time.Duration
+	next     atomic.Int64
+}
+
+// due reports whether the gauges are to be refreshed, only one of the
+// concurrent callers is.
+func (s *gaugeSampler) due(now time.Time) bool {
+	next := s.next.Load()
+	if now.UnixNano() < next {
+		return false
+	}
+	return s.next.CompareAndSwap(next, now.Add(s.interval).UnixNano())
+}
+
+func observe(req *http.Request, scope string, limiter *bbr.BBR, sampler *gaugeSampler, dropped bool) {
+	labels, ok := middleware.MetricsLabelsFromContext(req.Context())
+	if !ok {
+		return
+	}
+	values := []string{labels.Protocol(), labels.Method(), labels.Path(), labels.Service(), labels.BasePath(), scope}
+	if dropped {
+		_metricDroppedTotal.WithLabelValues(values...).Inc()
+	}
+	if !sampler.due(time.Now()) {
+		return
+	}
+	stat := limiter.Stat()
+	_metricInflight.WithLabelValues(values...).Set(float64(stat.InFlight))
+	_metricMaxPass.WithLabelValues(values...).Set(float64(stat.MaxPass))
+	_metricMaxInflight.WithLabelValues(values...).Set(float64(stat.MaxInFlight))
 }
 
 func Middleware(c *config.Middleware) (middleware.Middleware, error) {
-	limiter := bbr.NewLimiter() //use default settings
+	options := &BBR{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	opts, err := options.limiterOptions()
+	if err != nil {
+		return nil, err
+	}
+	var limiter *bbr.BBR
+	if options.Scope != "" {
+		limiter = scopeLimiter(options, opts)
+	} else {
+		limiter = bbr.NewLimiter(opts...)
+	}
+	sampler := &gaugeSampler{interval: options.bucketDuration()}
 	return func(next http.RoundTripper) http.RoundTripper {
 		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
 			done, err := limiter.Allow()
 			if err != nil {
-				return &http.Response{
-					Status:     http.StatusText(http.StatusTooManyRequests),
-					StatusCode: http.StatusTooManyRequests,
-					Body:       _nopBody,
-					Header:     make(http.Header),
-				}, nil
+				observe(req, options.Scope, limiter, sampler, true)
+				return render.NewResponse(req, render.NewError(http.StatusTooManyRequests, "")), nil
 			}
+			observe(req, options.Scope, limiter, sampler, false)
 			resp, err := next.RoundTrip(req)
 			done(ratelimit.DoneInfo{Err: err})
 			return resp, err
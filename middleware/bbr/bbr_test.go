@@ -0,0 +1,57 @@
+package bbr
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiterOptions(t *testing.T) {
+	tests := []struct {
+		options BBR
+		valid   bool
+	}{
+		{BBR{}, true},
+		{BBR{Window: 5 * time.Second, Bucket: 50, CPUThreshold: 900, CPUQuota: 2}, true},
+		{BBR{Window: 200 * time.Second}, false},
+		{BBR{Window: time.Nanosecond, Bucket: 10}, false},
+		{BBR{CPUThreshold: -1}, false},
+	}
+	for _, test := range tests {
+		_, err := test.options.limiterOptions()
+		if (err == nil) != test.valid {
+			t.Errorf("options %+v: unexpected error %v", test.options, err)
+		}
+	}
+}
+
+func TestScopeLimiter(t *testing.T) {
+	a := &BBR{Scope: "shared", CPUThreshold: 900}
+	opts, _ := a.limiterOptions()
+	l1 := scopeLimiter(a, opts)
+	l2 := scopeLimiter(&BBR{Scope: "shared", CPUThreshold: 900}, opts)
+	if l1 != l2 {
+		t.Fatal("expected the limiter of the scope to be shared")
+	}
+	b := &BBR{Scope: "shared", CPUThreshold: 700}
+	opts, _ = b.limiterOptions()
+	if scopeLimiter(b, opts) == l1 {
+		t.Fatal("expected a new limiter when the scope options change")
+	}
+	if scopeLimiter(&BBR{Scope: "other", CPUThreshold: 700}, opts) == l1 {
+		t.Fatal("expected scopes to have separate limiters")
+	}
+}
+
+func TestGaugeSampler(t *testing.T) {
+	s := &gaugeSampler{interval: (&BBR{}).bucketDuration()}
+	now := time.Unix(1000, 0)
+	if !s.due(now) {
+		t.Fatal("expected the first observation to refresh the gauges")
+	}
+	if s.due(now.Add(50 * time.Millisecond)) {
+		t.Fatal("expected no refresh within a bucket")
+	}
+	if !s.due(now.Add(100 * time.Millisecond)) {
+		t.Fatal("expected a refresh once the bucket elapsed")
+	}
+}
@@ -0,0 +1,228 @@
+package canary
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+func init() {
+	middleware.Register("canary", Middleware)
+}
+
+// groupKey holds the group chosen for the request.
+type groupKey struct{}
+
+// filterKey marks the request options already filtering the group nodes,
+// the options are shared by the retries but not by the hedged attempts.
+type filterKey struct {
+	opts *middleware.RequestOptions
+}
+
+type group struct {
+	name     string
+	version  string
+	metadata map[string]string
+	weight   int
+}
+
+// filter keeps the nodes of the group, all nodes are kept
+// when none of them belongs to the group.
+func (g *group) filter(_ context.Context, nodes []selector.Node) []selector.Node {
+	newNodes := make([]selector.Node, 0, len(nodes))
+	for _, n := range nodes {
+		if g.match(n) {
+			newNodes = append(newNodes, n)
+		}
+	}
+	if len(newNodes) == 0 {
+		return nodes
+	}
+	return newNodes
+}
+
+func (g *group) match(n selector.Node) bool {
+	if g.version != "" && n.Version() != g.version {
+		return false
+	}
+	md := n.Metadata()
+	for k, v := range g.metadata {
+		if md[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+type rule struct {
+	header *config.Header
+	cookie *config.Header
+	group  *group
+}
+
+func (r *rule) match(req *http.Request) bool {
+	if r.header != nil {
+		values, ok := req.Header[http.CanonicalHeaderKey(r.header.Name)]
+		if !ok || (r.header.Value != "" && !slices.Contains(values, r.header.Value)) {
+			return false
+		}
+	}
+	if r.cookie != nil {
+		cookie, err := req.Cookie(r.cookie.Name)
+		if err != nil || (r.cookie.Value != "" && cookie.Value != r.cookie.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+type canary struct {
+	groups      []*group
+	rules       []*rule
+	totalWeight int
+	hashOn      func(*http.Request) string
+}
+
+func parseHashOn(in string) (func(*http.Request) string, error) {
+	if in == "" {
+		return nil, nil
+	}
+	kind, name, _ := strings.Cut(in, ":")
+	switch strings.ToLower(kind) {
+	case "header":
+		return func(req *http.Request) string {
+			return req.Header.Get(name)
+		}, nil
+	case "cookie":
+		return func(req *http.Request) string {
+			cookie, err := req.Cookie(name)
+			if err != nil {
+				return ""
+			}
+			return cookie.Value
+		}, nil
+	case "query":
+		return func(req *http.Request) string {
+			return req.URL.Query().Get(name)
+		}, nil
+	}
+	return nil, fmt.Errorf("invalid canary hash on: %s", in)
+}
+
+func newCanary(options *config.Canary) (*canary, error) {
+	c := &canary{}
+	byName := make(map[string]*group, len(options.Groups))
+	for _, g := range options.Groups {
+		if g.Weight < 0 {
+			return nil, fmt.Errorf("invalid weight of canary group: %s", g.Name)
+		}
+		item := &group{
+			name:     g.Name,
+			version:  g.Version,
+			metadata: g.Metadata,
+			weight:   g.Weight,
+		}
+		byName[g.Name] = item
+		c.groups = append(c.groups, item)
+		c.totalWeight += g.Weight
+	}
+	for _, r := range options.Rules {
+		g, ok := byName[r.Group]
+		if !ok {
+			return nil, fmt.Errorf("unknown canary group: %s", r.Group)
+		}
+		c.rules = append(c.rules, &rule{header: r.Header, cookie: r.Cookie, group: g})
+	}
+	hashOn, err := parseHashOn(options.HashOn)
+	if err != nil {
+		return nil, err
+	}
+	c.hashOn = hashOn
+	return c, nil
+}
+
+// pick chooses the group of the request: the first matched rule wins,
+// otherwise the groups are split by weight, sticky on the hash key if present.
+func (c *canary) pick(req *http.Request) *group {
+	for _, r := range c.rules {
+		if r.match(req) {
+			return r.group
+		}
+	}
+	if c.totalWeight <= 0 {
+		return nil
+	}
+	var point int
+	if key := c.hashKey(req); key != "" {
+		h := fnv.New32a()
+		_, _ = h.Write([]byte(key))
+		point = int(h.Sum32() % uint32(c.totalWeight))
+	} else {
+		point = rand.Intn(c.totalWeight)
+	}
+	for _, g := range c.groups {
+		if point < g.weight {
+			return g
+		}
+		point -= g.weight
+	}
+	return nil
+}
+
+func (c *canary) hashKey(req *http.Request) string {
+	if c.hashOn == nil {
+		return ""
+	}
+	return c.hashOn(req)
+}
+
+// Middleware splits the traffic between groups of backend nodes
+// matched by their version and metadata.
+func Middleware(c *config.Middleware) (middleware.Middleware, error) {
+	options := &config.Canary{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	split, err := newCanary(options)
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+			reqOpt, ok := middleware.FromRequestContext(ctx)
+			if !ok {
+				return next.RoundTrip(req)
+			}
+			// retries and hedged attempts stay in the group chosen by the first attempt
+			var g *group
+			if v, picked := reqOpt.Values.Get(groupKey{}); picked {
+				g = v.(*group)
+			} else {
+				g = split.pick(req)
+				reqOpt.Values.Set(groupKey{}, g)
+			}
+			if g == nil {
+				return next.RoundTrip(req)
+			}
+			if _, ok := reqOpt.Values.Get(filterKey{reqOpt}); !ok {
+				reqOpt.Values.Set(filterKey{reqOpt}, struct{}{})
+				reqOpt.Metadata["canary"] = g.name
+				middleware.WithSelectorFitler(ctx, g.filter)
+			}
+			return next.RoundTrip(req)
+		})
+	}, nil
+}
@@ -0,0 +1,175 @@
+package canary
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+type testNode struct {
+	selector.Node
+	address string
+	version string
+}
+
+func (n *testNode) Address() string             { return n.address }
+func (n *testNode) Version() string             { return n.version }
+func (n *testNode) Metadata() map[string]string { return nil }
+
+// pool selects the node of the X-Prefer version out of the nodes left by the
+// filters of the request, the first one otherwise.
+type pool struct {
+	nodes []selector.Node
+}
+
+func (p *pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqOpt, _ := middleware.FromRequestContext(req.Context())
+	nodes := append([]selector.Node(nil), p.nodes...)
+	for _, f := range reqOpt.Filters {
+		nodes = f(req.Context(), nodes)
+	}
+	reqOpt.CurrentNode = nodes[0]
+	if prefer := req.Header.Get("X-Prefer"); prefer != "" {
+		for _, n := range nodes {
+			if n.Version() == prefer {
+				reqOpt.CurrentNode = n
+			}
+		}
+	}
+	return httptest.NewRecorder().Result(), nil
+}
+
+var testPool = &pool{nodes: []selector.Node{
+	&testNode{address: "10.0.0.1:80", version: "v1"},
+	&testNode{address: "10.0.0.2:80", version: "v2"},
+}}
+
+func newCanaryMiddleware(t *testing.T, options map[string]any) http.RoundTripper {
+	t.Helper()
+	m, err := Middleware(&config.Middleware{Name: "canary", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m(testPool)
+}
+
+func newRequest(header http.Header) (*http.Request, *middleware.RequestOptions) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	reqOpt := middleware.NewRequestOptions(&config.Endpoint{Path: "/"})
+	return req.WithContext(middleware.NewRequestContext(context.Background(), reqOpt)), reqOpt
+}
+
+func version(t *testing.T, rt http.RoundTripper, req *http.Request) string {
+	t.Helper()
+	if _, err := rt.RoundTrip(req); err != nil {
+		t.Fatal(err)
+	}
+	reqOpt, _ := middleware.FromRequestContext(req.Context())
+	return reqOpt.CurrentNode.Version()
+}
+
+var testGroups = []map[string]any{
+	{"name": "stable", "version": "v1", "weight": 100},
+	{"name": "canary", "version": "v2", "weight": 0},
+}
+
+func TestRules(t *testing.T) {
+	if _, err := Middleware(&config.Middleware{Name: "canary", Options: map[string]any{
+		"groups": testGroups,
+		"rules":  []map[string]any{{"group": "unknown"}},
+	}}); err == nil {
+		t.Fatal("expected an unknown group to fail")
+	}
+	rt := newCanaryMiddleware(t, map[string]any{
+		"groups": testGroups,
+		"rules": []map[string]any{
+			{"header": map[string]any{"name": "X-Canary", "value": "on"}, "group": "canary"},
+			{"cookie": map[string]any{"name": "beta"}, "group": "canary"},
+		},
+	})
+	tests := []struct {
+		header   http.Header
+		expected string
+	}{
+		{header: http.Header{}, expected: "v1"},
+		{header: http.Header{"X-Canary": {"off"}}, expected: "v1"},
+		// any value of the header matches the rule
+		{header: http.Header{"X-Canary": {"off", "on"}}, expected: "v2"},
+		{header: http.Header{"Cookie": {"beta=1"}}, expected: "v2"},
+	}
+	for _, tt := range tests {
+		req, reqOpt := newRequest(tt.header)
+		if got := version(t, rt, req); got != tt.expected {
+			t.Errorf("%v: expected %s, got %s", tt.header, tt.expected, got)
+		}
+		if tt.expected == "v2" && reqOpt.Metadata["canary"] != "canary" {
+			t.Errorf("%v: expected the canary group in the metadata, got %v", tt.header, reqOpt.Metadata)
+		}
+	}
+}
+
+func TestWeights(t *testing.T) {
+	rt := newCanaryMiddleware(t, map[string]any{"groups": []map[string]any{
+		{"name": "stable", "version": "v1", "weight": 50},
+		{"name": "canary", "version": "v2", "weight": 50},
+	}})
+	counts := map[string]int{}
+	for i := 0; i < 1000; i++ {
+		req, _ := newRequest(nil)
+		counts[version(t, rt, req)]++
+	}
+	if counts["v1"] < 400 || counts["v2"] < 400 {
+		t.Fatalf("expected the traffic to be split by weight, got %v", counts)
+	}
+
+	// the hash key sticks a client to its group
+	rt = newCanaryMiddleware(t, map[string]any{"hashOn": "header:X-User", "groups": []map[string]any{
+		{"name": "stable", "version": "v1", "weight": 50},
+		{"name": "canary", "version": "v2", "weight": 50},
+	}})
+	for i := 0; i < 20; i++ {
+		user := http.Header{"X-User": {string(rune('a' + i))}}
+		req, _ := newRequest(user)
+		first := version(t, rt, req)
+		for j := 0; j < 5; j++ {
+			req, _ = newRequest(user)
+			if got := version(t, rt, req); got != first {
+				t.Fatalf("user %d: expected %s, got %s", i, first, got)
+			}
+		}
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	rt := newCanaryMiddleware(t, map[string]any{
+		"groups": testGroups,
+		"rules":  []map[string]any{{"header": map[string]any{"name": "X-Canary"}, "group": "canary"}},
+	})
+	req, reqOpt := newRequest(http.Header{"X-Canary": {"on"}, "X-Prefer": {"v1"}})
+	if got := version(t, rt, req); got != "v2" {
+		t.Fatalf("expected the canary group, got %s", got)
+	}
+	// a retry keeps the single filter of its options
+	filters := len(reqOpt.Filters)
+	if got := version(t, rt, req); got != "v2" || len(reqOpt.Filters) != filters {
+		t.Fatalf("expected the retry to keep the group filter once, got %s with %d filters", got, len(reqOpt.Filters))
+	}
+
+	// a hedged attempt is filtered into the group chosen by the first attempt,
+	// even though its own request no longer matches the rule
+	attempt := middleware.NewAttemptOptions(reqOpt)
+	hedged := req.Clone(middleware.NewRequestContext(context.Background(), attempt))
+	hedged.Header.Del("X-Canary")
+	if got := version(t, rt, hedged); got != "v2" {
+		t.Fatalf("expected the hedged attempt in the canary group, got %s", got)
+	}
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/limes-cloud/gateway/client"
 	"github.com/limes-cloud/gateway/middleware"
 	"github.com/limes-cloud/gateway/proxy/condition"
+	"github.com/limes-cloud/gateway/proxy/render"
 	"github.com/prometheus/client_golang/prometheus"
 	"golang.org/x/exp/rand"
 )
@@ -117,13 +118,8 @@ func makeOnBreakHandler(in *config.CircuitBreaker, factory client.Factory) (http
 	}
 
 	log.Warnf("Unrecoginzed circuit breaker aciton")
-	return middleware.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
-		// TBD: on break response
-		return &http.Response{
-			StatusCode: http.StatusServiceUnavailable,
-			Header:     http.Header{},
-			Body:       io.NopCloser(&bytes.Buffer{}),
-		}, nil
+	return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return render.NewResponse(req, render.NewError(http.StatusServiceUnavailable, "circuit breaker is open")), nil
 	}), io.NopCloser(nil), nil
 }
 
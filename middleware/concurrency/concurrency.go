@@ -0,0 +1,245 @@
+package concurrency
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+const (
+	scopeEndpoint = "endpoint"
+	scopeService  = "service"
+
+	queueFIFO     = "fifo"
+	queuePriority = "priority"
+
+	defaultMaxWait = time.Second
+)
+
+func init() {
+	middleware.Register("concurrency", Middleware)
+	prometheus.MustRegister(_metricInflight, _metricQueueDepth, _metricWaitSeconds, _metricRejectedTotal)
+}
+
+var (
+	_metricLabels = []string{"protocol", "method", "path", "service", "basePath"}
+
+	_metricInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "concurrency_inflight",
+		Help:      "The number of in-flight requests of the concurrency limiter",
+	}, _metricLabels)
+	_metricQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "concurrency_queue_depth",
+		Help:      "The number of requests waiting for the concurrency limiter",
+	}, _metricLabels)
+	_metricWaitSeconds = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "concurrency_wait_seconds",
+		Help:      "The time requests waited for the concurrency limiter",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, _metricLabels)
+	_metricRejectedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "concurrency_rejected_total",
+		Help:      "The total number of requests rejected by the concurrency limiter",
+	}, append(_metricLabels, "reason"))
+)
+
+type ConsumerPriority struct {
+	Consumer string
+	Priority int
+}
+
+type Concurrency struct {
+	MaxConcurrency int
+	// Scope is endpoint or service, endpoints of the same service
+	// share one limiter with the service scope.
+	Scope     string
+	QueueSize int
+	MaxWait   time.Duration
+	// Queue is fifo or priority, requests with a higher priority are served first.
+	Queue string
+	// PriorityHeader carries the integer priority of the request,
+	// the priority of the consumer wins over it.
+	PriorityHeader  string
+	Consumers       []ConsumerPriority
+	DefaultPriority int
+}
+
+// priority returns the queue priority of the request.
+func (o *Concurrency) priority(req *http.Request, reqOpt *middleware.RequestOptions, consumers map[string]int) int {
+	if o.Queue != queuePriority {
+		return 0
+	}
+	if reqOpt != nil {
+		if p, ok := consumers[reqOpt.Metadata["consumer"]]; ok {
+			return p
+		}
+	}
+	if o.PriorityHeader != "" {
+		if p, err := strconv.Atoi(req.Header.Get(o.PriorityHeader)); err == nil {
+			return p
+		}
+	}
+	return o.DefaultPriority
+}
+
+type serviceLimiter struct {
+	max       int
+	queueSize int
+	limiter   *limiter
+}
+
+var (
+	_servicesLock sync.Mutex
+	_services     = map[string]*serviceLimiter{}
+)
+
+// serviceScope returns the limiter shared by the endpoints of the service,
+// it is kept across config reloads unless its limits change.
+func serviceScope(service string, max, queueSize int) *limiter {
+	_servicesLock.Lock()
+	defer _servicesLock.Unlock()
+	if s, ok := _services[service]; ok && s.max == max && s.queueSize == queueSize {
+		return s.limiter
+	}
+	s := &serviceLimiter{max: max, queueSize: queueSize, limiter: newLimiter(max, queueSize)}
+	_services[service] = s
+	return s.limiter
+}
+
+// releaseBody releases the slot once the response has been read.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, errQueueFull):
+		return "queue_full"
+	case errors.Is(err, errWaitTimeout):
+		return "timeout"
+	}
+	return "canceled"
+}
+
+// Middleware caps the concurrent requests of the endpoint or of its service,
+// the excess waits in a bounded queue and is rejected after the max wait.
+func Middleware(c *config.Middleware) (middleware.Middleware, error) {
+	options := &Concurrency{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	if options.MaxConcurrency <= 0 {
+		return nil, errors.New("concurrency requires a positive max concurrency")
+	}
+	if options.QueueSize < 0 {
+		return nil, errors.New("concurrency queue size must not be negative")
+	}
+	if options.MaxWait <= 0 {
+		options.MaxWait = defaultMaxWait
+	}
+	switch options.Scope {
+	case "":
+		options.Scope = scopeEndpoint
+	case scopeEndpoint, scopeService:
+	default:
+		return nil, fmt.Errorf("unknown concurrency scope: %s", options.Scope)
+	}
+	switch options.Queue {
+	case "":
+		options.Queue = queueFIFO
+	case queueFIFO, queuePriority:
+	default:
+		return nil, fmt.Errorf("unknown concurrency queue: %s", options.Queue)
+	}
+	consumers := make(map[string]int, len(options.Consumers))
+	for _, c := range options.Consumers {
+		consumers[c.Consumer] = c.Priority
+	}
+	endpointLimiter := newLimiter(options.MaxConcurrency, options.QueueSize)
+	limiterOf := func(reqOpt *middleware.RequestOptions) *limiter {
+		if options.Scope == scopeService && reqOpt != nil && reqOpt.Endpoint != nil {
+			if service := reqOpt.Endpoint.Metadata["service"]; service != "" {
+				return serviceScope(service, options.MaxConcurrency, options.QueueSize)
+			}
+		}
+		return endpointLimiter
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			reqOpt, ok := middleware.FromRequestContext(req.Context())
+			if !ok {
+				reqOpt = nil
+			}
+			labels, hasLabels := middleware.MetricsLabelsFromContext(req.Context())
+			var values []string
+			if hasLabels {
+				values = []string{labels.Protocol(), labels.Method(), labels.Path(), labels.Service(), labels.BasePath()}
+			}
+			l := limiterOf(reqOpt)
+			observe := func() {
+				if !hasLabels {
+					return
+				}
+				inflight, queued := l.Stat()
+				_metricInflight.WithLabelValues(values...).Set(float64(inflight))
+				_metricQueueDepth.WithLabelValues(values...).Set(float64(queued))
+			}
+
+			start := time.Now()
+			err := l.Acquire(req.Context(), options.priority(req, reqOpt, consumers), options.MaxWait)
+			if hasLabels {
+				_metricWaitSeconds.WithLabelValues(values...).Observe(time.Since(start).Seconds())
+			}
+			if err != nil {
+				if hasLabels {
+					_metricRejectedTotal.WithLabelValues(append(values, rejectReason(err))...).Inc()
+				}
+				observe()
+				return render.NewResponse(req, render.NewError(http.StatusServiceUnavailable, err.Error())), nil
+			}
+			observe()
+			release := func() {
+				l.Release()
+				observe()
+			}
+
+			resp, err := next.RoundTrip(req)
+			if err != nil || resp.Body == nil {
+				release()
+				return resp, err
+			}
+			resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+			return resp, nil
+		})
+	}, nil
+}
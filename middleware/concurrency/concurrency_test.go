@@ -0,0 +1,99 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+func TestLimiterPriority(t *testing.T) {
+	l := newLimiter(1, 3)
+	if err := l.Acquire(context.Background(), 0, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	var (
+		lock  sync.Mutex
+		order []int
+		wg    sync.WaitGroup
+	)
+	for i, p := range []int{1, 5, 1} {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			if err := l.Acquire(context.Background(), p, time.Second); err != nil {
+				t.Error(err)
+				return
+			}
+			lock.Lock()
+			order = append(order, p)
+			lock.Unlock()
+			l.Release()
+		}(p)
+		// keep the arrival order of the waiters
+		for _, queued := l.Stat(); queued != i+1; _, queued = l.Stat() {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	if err := l.Acquire(context.Background(), 0, time.Second); !errors.Is(err, errQueueFull) {
+		t.Fatalf("expected queue full, got %v", err)
+	}
+	l.Release()
+	wg.Wait()
+	if len(order) != 3 || order[0] != 5 {
+		t.Fatalf("expected the highest priority first, got %v", order)
+	}
+	if inflight, queued := l.Stat(); inflight != 0 || queued != 0 {
+		t.Fatalf("unexpected state: %d inflight, %d queued", inflight, queued)
+	}
+}
+
+func TestLimiterTimeout(t *testing.T) {
+	l := newLimiter(1, 1)
+	_ = l.Acquire(context.Background(), 0, time.Second)
+	if err := l.Acquire(context.Background(), 0, 10*time.Millisecond); !errors.Is(err, errWaitTimeout) {
+		t.Fatalf("expected timeout, got %v", err)
+	}
+	if _, queued := l.Stat(); queued != 0 {
+		t.Fatalf("expected the waiter to leave the queue, got %d", queued)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	m, err := Middleware(&config.Middleware{Name: "concurrency", Options: map[string]any{
+		"maxConcurrency": 1,
+		"maxWait":        "10ms",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := m(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return httptest.NewRecorder().Result(), nil
+	}))
+	resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the slot is held until the body is closed
+	second, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503, got %d", second.StatusCode)
+	}
+	resp.Body.Close()
+	resp, err = rt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,123 @@
+package concurrency
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errQueueFull   = errors.New("concurrency queue is full")
+	errWaitTimeout = errors.New("concurrency wait timeout")
+)
+
+type waiter struct {
+	priority int
+	seq      uint64
+	index    int
+	ready    chan struct{}
+}
+
+// waitQueue is a heap of waiters, the highest priority first
+// and the oldest first within the same priority.
+type waitQueue []*waiter
+
+func (q waitQueue) Len() int { return len(q) }
+func (q waitQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q waitQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+func (q *waitQueue) Push(x any) {
+	w := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+func (q *waitQueue) Pop() any {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
+
+// limiter caps the in-flight requests, the excess waits in a bounded queue.
+type limiter struct {
+	lock      sync.Mutex
+	max       int
+	queueSize int
+	inflight  int
+	seq       uint64
+	waiters   waitQueue
+}
+
+func newLimiter(max, queueSize int) *limiter {
+	return &limiter{max: max, queueSize: queueSize}
+}
+
+// Acquire takes a slot, waiting at most maxWait for one to be released.
+func (l *limiter) Acquire(ctx context.Context, priority int, maxWait time.Duration) error {
+	l.lock.Lock()
+	if l.inflight < l.max && len(l.waiters) == 0 {
+		l.inflight++
+		l.lock.Unlock()
+		return nil
+	}
+	if len(l.waiters) >= l.queueSize {
+		l.lock.Unlock()
+		return errQueueFull
+	}
+	l.seq++
+	w := &waiter{priority: priority, seq: l.seq, ready: make(chan struct{})}
+	heap.Push(&l.waiters, w)
+	l.lock.Unlock()
+
+	timer := time.NewTimer(maxWait)
+	defer timer.Stop()
+	var err error
+	select {
+	case <-w.ready:
+		return nil
+	case <-timer.C:
+		err = errWaitTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if w.index < 0 {
+		// the slot has been handed over while giving up
+		return nil
+	}
+	heap.Remove(&l.waiters, w.index)
+	return err
+}
+
+// Release hands the slot over to the first waiter, if any.
+func (l *limiter) Release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if len(l.waiters) > 0 {
+		w := heap.Pop(&l.waiters).(*waiter)
+		close(w.ready)
+		return
+	}
+	l.inflight--
+}
+
+// Stat returns the number of in-flight and queued requests.
+func (l *limiter) Stat() (int, int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inflight, len(l.waiters)
+}
@@ -12,6 +12,7 @@ import (
 	"time"
 
 	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
 )
 
 var (
@@ -96,7 +97,7 @@ func Middleware(c *config.Middleware) (middleware.Middleware, error) {
 				return next.RoundTrip(req)
 			}
 			if !isOriginAllowed(origin, options.AllowOrigins) {
-				return newResponse(http.StatusForbidden, http.Header{})
+				return render.NewResponse(req, render.NewError(http.StatusForbidden, "origin is not allowed")), nil
 			}
 			if req.Method == corsOptionMethod {
 				headers := make(http.Header, len(preflightHeaders)+2)
@@ -0,0 +1,66 @@
+package ipfilter
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
+	"github.com/limes-cloud/gateway/utils"
+	"github.com/limes-cloud/gateway/utils/clientip"
+)
+
+func init() {
+	middleware.Register("ipfilter", Middleware)
+}
+
+type IPFilter struct {
+	// Allow are the CIDRs or addresses allowed, every address is allowed if empty.
+	Allow []string
+	// Deny are the CIDRs or addresses denied, they win over the allowed ones.
+	Deny []string
+}
+
+type filter struct {
+	allow clientip.Prefixes
+	deny  clientip.Prefixes
+}
+
+func (f *filter) allowed(ip string) bool {
+	if f.deny.ContainsString(ip) {
+		return false
+	}
+	return len(f.allow) == 0 || f.allow.ContainsString(ip)
+}
+
+// Middleware allows or denies requests by the client address,
+// resolved behind the trusted proxies of the gateway.
+func Middleware(c *config.Middleware) (middleware.Middleware, error) {
+	options := &IPFilter{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	if len(options.Allow) == 0 && len(options.Deny) == 0 {
+		return nil, errors.New("ipfilter requires allow or deny rules")
+	}
+	allow, err := clientip.ParsePrefixes(options.Allow)
+	if err != nil {
+		return nil, err
+	}
+	deny, err := clientip.ParsePrefixes(options.Deny)
+	if err != nil {
+		return nil, err
+	}
+	f := &filter{allow: allow, deny: deny}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			if !f.allowed(middleware.ClientIP(req)) {
+				return render.NewResponse(req, render.NewError(http.StatusForbidden, "client address is not allowed")), nil
+			}
+			return next.RoundTrip(req)
+		})
+	}, nil
+}
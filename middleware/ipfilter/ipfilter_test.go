@@ -0,0 +1,50 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+func TestMiddleware(t *testing.T) {
+	m, err := Middleware(&config.Middleware{Name: "ipfilter", Options: map[string]any{
+		"allow": "10.0.0.0/8,192.168.0.0/16",
+		"deny":  []string{"10.0.0.13"},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rt := m(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return httptest.NewRecorder().Result(), nil
+	}))
+	e := &config.Endpoint{Path: "/"}
+	tests := []struct {
+		remote   string
+		clientIP string
+		code     int
+	}{
+		{remote: "10.1.1.1:80", code: http.StatusOK},
+		{remote: "10.0.0.13:80", code: http.StatusForbidden},
+		{remote: "8.8.8.8:80", code: http.StatusForbidden},
+		// the resolved client address wins over the peer one
+		{remote: "10.1.1.1:80", clientIP: "8.8.8.8", code: http.StatusForbidden},
+		{remote: "8.8.8.8:80", clientIP: "192.168.3.3", code: http.StatusOK},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = test.remote
+		reqOpt := middleware.NewRequestOptions(e)
+		reqOpt.ClientIP = test.clientIP
+		req = req.WithContext(middleware.NewRequestContext(req.Context(), reqOpt))
+		resp, err := rt.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != test.code {
+			t.Errorf("remote %s client %s: expected %d, got %d", test.remote, test.clientIP, test.code, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package jwt
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+)
+
+const (
+	_defaultRefreshInterval = 5 * time.Minute
+	_defaultFetchTimeout    = 5 * time.Second
+)
+
+var (
+	// _minRefreshInterval limits the refreshes triggered by unknown key ids.
+	_minRefreshInterval = 10 * time.Second
+
+	errKeyNotFound = errors.New("signing key not found")
+)
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+func decodeSegment(in string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(in)
+}
+
+// parseJWK returns the public key described by the JSON web key,
+// see https://www.rfc-editor.org/rfc/rfc7518#section-6
+func parseJWK(k *jwk) (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() > 1<<31-1 {
+			return nil, fmt.Errorf("invalid rsa exponent of key: %s", k.Kid)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q of key: %s", k.Crv, k.Kid)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid ec point of key: %s", k.Kid)
+		}
+		return key, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q of key: %s", k.Crv, k.Kid)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key: %s", k.Kid)
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		return decodeSegment(k.K)
+	}
+	return nil, fmt.Errorf("unsupported key type %q of key: %s", k.Kty, k.Kid)
+}
+
+// parsePublicKey parses a PEM encoded public key or certificate.
+func parsePublicKey(in string) (any, error) {
+	block, _ := pem.Decode([]byte(in))
+	if block == nil {
+		return nil, errors.New("invalid pem public key")
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// keySet caches the keys of a JWKS endpoint and refreshes them in background.
+type keySet struct {
+	url      string
+	client   *http.Client
+	interval time.Duration
+
+	lock        sync.RWMutex
+	keys        map[string]any
+	lastRefresh time.Time
+
+	refreshLock sync.Mutex
+	refs        int
+	done        chan struct{}
+}
+
+func newKeySet(options *JWKS) *keySet {
+	interval := options.RefreshInterval
+	if interval <= 0 {
+		interval = _defaultRefreshInterval
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = _defaultFetchTimeout
+	}
+	return &keySet{
+		url:      options.URL,
+		client:   &http.Client{Timeout: timeout},
+		interval: interval,
+		keys:     map[string]any{},
+		done:     make(chan struct{}),
+	}
+}
+
+// run loads the keys then refreshes them until the key set is released,
+// an unreachable JWKS endpoint must not prevent the gateway from starting.
+func (s *keySet) run() {
+	if err := s.refresh(); err != nil {
+		log.Errorf("Failed to fetch jwks from %s: %+v", s.url, err)
+	}
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+			if err := s.refresh(); err != nil {
+				log.Errorf("Failed to refresh jwks from %s: %+v", s.url, err)
+			}
+		}
+	}
+}
+
+func (s *keySet) fetch() (map[string]any, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected jwks response status: %d", resp.StatusCode)
+	}
+	set := &jwkSet{}
+	if err := json.NewDecoder(resp.Body).Decode(set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for i := range set.Keys {
+		k := &set.Keys[i]
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			log.Warnf("Skip invalid jwk from %s: %+v", s.url, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func (s *keySet) refresh() error {
+	s.refreshLock.Lock()
+	defer s.refreshLock.Unlock()
+	return s.doRefresh()
+}
+
+// refreshStale refreshes the keys unless they have just been refreshed,
+// concurrent callers wait for a single fetch.
+func (s *keySet) refreshStale() error {
+	s.refreshLock.Lock()
+	defer s.refreshLock.Unlock()
+	s.lock.RLock()
+	stale := time.Since(s.lastRefresh) >= _minRefreshInterval
+	s.lock.RUnlock()
+	if !stale {
+		return nil
+	}
+	return s.doRefresh()
+}
+
+func (s *keySet) doRefresh() error {
+	keys, err := s.fetch()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.lastRefresh = time.Now()
+	if err != nil {
+		return err
+	}
+	s.keys = keys
+	return nil
+}
+
+func (s *keySet) lookup(kid string) (any, bool) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, true
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+// get returns the key of kid, an unknown key id triggers a refresh
+// in case the signing keys have been rotated.
+func (s *keySet) get(kid string) (any, error) {
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	if err := s.refreshStale(); err != nil {
+		log.Errorf("Failed to refresh jwks from %s: %+v", s.url, err)
+	}
+	if key, ok := s.lookup(kid); ok {
+		return key, nil
+	}
+	return nil, errKeyNotFound
+}
+
+// keySetRegistry shares the key set of a JWKS url between the middlewares,
+// so that a reload neither fetches the keys again nor leaks refreshers.
+type keySetRegistry struct {
+	lock sync.Mutex
+	sets map[string]*keySet
+}
+
+var _keySetRegistry = &keySetRegistry{sets: map[string]*keySet{}}
+
+// acquire returns the key set of the url, it is loaded in background by its
+// first subscriber whose refresh interval and timeout apply.
+func (r *keySetRegistry) acquire(options *JWKS) *keySet {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	s, ok := r.sets[options.URL]
+	if !ok {
+		s = newKeySet(options)
+		r.sets[options.URL] = s
+		go s.run()
+	}
+	s.refs++
+	return s
+}
+
+// release stops the key set once it has no subscriber left.
+func (r *keySetRegistry) release(s *keySet) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if s.refs--; s.refs > 0 {
+		return
+	}
+	close(s.done)
+	delete(r.sets, s.url)
+}
+
+// keySetRef is the reference of a middleware to a shared key set.
+type keySetRef struct {
+	set  *keySet
+	once sync.Once
+}
+
+func (r *keySetRef) Close() error {
+	r.once.Do(func() {
+		_keySetRegistry.release(r.set)
+	})
+	return nil
+}
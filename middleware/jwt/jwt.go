@@ -0,0 +1,277 @@
+package jwt
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+func init() {
+	middleware.RegisterV2("jwt", Middleware)
+}
+
+var _defaultAlgorithms = []string{"HS256", "RS256", "ES256", "EdDSA"}
+
+type JWKS struct {
+	URL             string
+	RefreshInterval time.Duration
+	Timeout         time.Duration
+}
+
+type Forward struct {
+	Claim  string
+	Header string
+}
+
+type JWT struct {
+	Algorithms []string
+	Secret     string
+	PublicKey  string
+	JWKS       *JWKS
+	Issuer     string
+	Audience   []string
+	ClockSkew  time.Duration
+	Header     string
+	Scheme     string
+	Cookie     string
+	Scopes     []string
+	ScopeClaim string
+	Forwards   []Forward
+}
+
+type claimsKey struct{}
+
+// FromContext returns the claims of the token validated for the request.
+func FromContext(ctx context.Context) (jwtv5.MapClaims, bool) {
+	reqOpt, ok := middleware.FromRequestContext(ctx)
+	if !ok {
+		return nil, false
+	}
+	v, ok := reqOpt.Values.Get(claimsKey{})
+	if !ok {
+		return nil, false
+	}
+	claims, ok := v.(jwtv5.MapClaims)
+	return claims, ok
+}
+
+// ClaimString returns a claim as a string, lists are joined with commas.
+func ClaimString(claims jwtv5.MapClaims, name string) (string, bool) {
+	v, ok := claims[name]
+	if !ok || v == nil {
+		return "", false
+	}
+	switch value := v.(type) {
+	case string:
+		return value, true
+	case []any:
+		items := make([]string, 0, len(value))
+		for _, item := range value {
+			items = append(items, fmt.Sprint(item))
+		}
+		return strings.Join(items, ","), true
+	case float64:
+		// avoid the exponent format of large numbers such as user ids
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	default:
+		return fmt.Sprint(value), true
+	}
+}
+
+type validator struct {
+	options *JWT
+	parser  *jwtv5.Parser
+	key     any
+	keys    *keySet
+}
+
+func newValidator(options *JWT) (*validator, error) {
+	if options.Secret == "" && options.PublicKey == "" && (options.JWKS == nil || options.JWKS.URL == "") {
+		return nil, errors.New("jwt requires a secret, a public key or a jwks url")
+	}
+	if options.Header == "" && options.Cookie == "" {
+		options.Header = "Authorization"
+	}
+	if options.Header == "Authorization" && options.Scheme == "" {
+		options.Scheme = "Bearer"
+	}
+	if options.ScopeClaim == "" {
+		options.ScopeClaim = "scope"
+	}
+	algorithms := options.Algorithms
+	if len(algorithms) == 0 {
+		algorithms = _defaultAlgorithms
+	}
+	parserOptions := []jwtv5.ParserOption{
+		jwtv5.WithValidMethods(algorithms),
+		jwtv5.WithLeeway(options.ClockSkew),
+		jwtv5.WithExpirationRequired(),
+	}
+	if options.Issuer != "" {
+		parserOptions = append(parserOptions, jwtv5.WithIssuer(options.Issuer))
+	}
+	if len(options.Audience) > 0 {
+		parserOptions = append(parserOptions, jwtv5.WithAudience(options.Audience...))
+	}
+	v := &validator{
+		options: options,
+		parser:  jwtv5.NewParser(parserOptions...),
+	}
+	if options.PublicKey != "" {
+		key, err := parsePublicKey(options.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		v.key = key
+	}
+	if options.JWKS != nil && options.JWKS.URL != "" {
+		v.keys = _keySetRegistry.acquire(options.JWKS)
+	}
+	return v, nil
+}
+
+// keyFunc returns the verification key of the token, golang-jwt rejects
+// a key whose type does not match the signing method.
+func (v *validator) keyFunc(token *jwtv5.Token) (any, error) {
+	if _, ok := token.Method.(*jwtv5.SigningMethodHMAC); ok && v.options.Secret != "" {
+		return []byte(v.options.Secret), nil
+	}
+	if v.keys != nil {
+		kid, _ := token.Header["kid"].(string)
+		key, err := v.keys.get(kid)
+		if err == nil || v.key == nil {
+			return key, err
+		}
+	}
+	if v.key != nil {
+		return v.key, nil
+	}
+	return nil, errKeyNotFound
+}
+
+func (v *validator) extract(req *http.Request) string {
+	if v.options.Header != "" {
+		value := req.Header.Get(v.options.Header)
+		if v.options.Scheme == "" {
+			if value != "" {
+				return value
+			}
+		} else if len(value) > len(v.options.Scheme) && strings.EqualFold(value[:len(v.options.Scheme)+1], v.options.Scheme+" ") {
+			return strings.TrimSpace(value[len(v.options.Scheme)+1:])
+		}
+	}
+	if v.options.Cookie != "" {
+		if cookie, err := req.Cookie(v.options.Cookie); err == nil {
+			return cookie.Value
+		}
+	}
+	return ""
+}
+
+func (v *validator) validate(req *http.Request) (jwtv5.MapClaims, error) {
+	raw := v.extract(req)
+	if raw == "" {
+		return nil, errors.New("missing token")
+	}
+	claims := jwtv5.MapClaims{}
+	if _, err := v.parser.ParseWithClaims(raw, claims, v.keyFunc); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func scopesOf(claims jwtv5.MapClaims, name string) map[string]struct{} {
+	out := map[string]struct{}{}
+	switch value := claims[name].(type) {
+	case string:
+		for _, scope := range strings.Fields(value) {
+			out[scope] = struct{}{}
+		}
+	case []any:
+		for _, scope := range value {
+			if s, ok := scope.(string); ok {
+				out[s] = struct{}{}
+			}
+		}
+	}
+	return out
+}
+
+func (v *validator) hasScopes(claims jwtv5.MapClaims) bool {
+	if len(v.options.Scopes) == 0 {
+		return true
+	}
+	granted := scopesOf(claims, v.options.ScopeClaim)
+	for _, scope := range v.options.Scopes {
+		if _, ok := granted[scope]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func unauthorized(req *http.Request, code, message string) *http.Response {
+	statusCode := http.StatusUnauthorized
+	if code == "insufficient_scope" {
+		statusCode = http.StatusForbidden
+	}
+	resp := render.NewResponse(req, render.NewError(statusCode, message))
+	// see https://www.rfc-editor.org/rfc/rfc6750#section-3
+	resp.Header.Set("WWW-Authenticate", fmt.Sprintf(`Bearer error=%q, error_description=%q`, code, message))
+	return resp
+}
+
+// Middleware validates the JSON web token of the request locally,
+// the claims can be forwarded to the upstream as headers.
+func Middleware(c *config.Middleware) (middleware.MiddlewareV2, error) {
+	options := &JWT{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	v, err := newValidator(options)
+	if err != nil {
+		return nil, err
+	}
+	var closer io.Closer = io.NopCloser(nil)
+	if v.keys != nil {
+		closer = &keySetRef{set: v.keys}
+	}
+	return middleware.NewWithCloser(func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// never trust the forwarded claim headers sent by the client
+			for _, f := range options.Forwards {
+				req.Header.Del(f.Header)
+			}
+			claims, err := v.validate(req)
+			if err != nil {
+				return unauthorized(req, "invalid_token", err.Error()), nil
+			}
+			if !v.hasScopes(claims) {
+				return unauthorized(req, "insufficient_scope", "insufficient scope"), nil
+			}
+			for _, f := range options.Forwards {
+				if value, ok := ClaimString(claims, f.Claim); ok {
+					req.Header.Set(f.Header, value)
+				}
+			}
+			if reqOpt, ok := middleware.FromRequestContext(req.Context()); ok {
+				reqOpt.Values.Set(claimsKey{}, claims)
+			}
+			return next.RoundTrip(req)
+		})
+	}, closer), nil
+}
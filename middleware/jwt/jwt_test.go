@@ -0,0 +1,292 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	jwtv5 "github.com/golang-jwt/jwt/v5"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+type jwksServer struct {
+	*httptest.Server
+	lock  sync.Mutex
+	keys  map[string]*rsa.PrivateKey
+	count int
+}
+
+func newJWKSServer(t *testing.T) *jwksServer {
+	s := &jwksServer{keys: map[string]*rsa.PrivateKey{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.count++
+		set := jwkSet{}
+		for kid, key := range s.keys {
+			set.Keys = append(set.Keys, jwk{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			})
+		}
+		_ = json.NewEncoder(w).Encode(set)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *jwksServer) rotate(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.keys[kid] = key
+	return key
+}
+
+func sign(t *testing.T, method jwtv5.SigningMethod, kid string, key any, claims jwtv5.MapClaims) string {
+	token := jwtv5.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	out, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func newTestMiddleware(t *testing.T, options map[string]any) http.RoundTripper {
+	m, err := Middleware(&config.Middleware{Name: "jwt", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+	return m.Process(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		w := httptest.NewRecorder()
+		w.Header().Set("X-User-Id", req.Header.Get("X-User-Id"))
+		return w.Result(), nil
+	}))
+}
+
+func roundTrip(t *testing.T, tripper http.RoundTripper, token string) *http.Response {
+	req := httptest.NewRequest(http.MethodGet, "/api/users", nil)
+	req.Header.Set("X-User-Id", "spoofed")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := tripper.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestJWKS(t *testing.T) {
+	minRefreshInterval := _minRefreshInterval
+	_minRefreshInterval = 200 * time.Millisecond
+	defer func() { _minRefreshInterval = minRefreshInterval }()
+
+	server := newJWKSServer(t)
+	key := server.rotate(t, "k1")
+	tripper := newTestMiddleware(t, map[string]any{
+		"jwks":      map[string]any{"url": server.URL},
+		"issuer":    "https://issuer.example.com",
+		"audience":  "gateway",
+		"clockSkew": "30s",
+		"scopes":    "users:read",
+		"forwards":  []map[string]any{{"claim": "sub", "header": "X-User-Id"}},
+	})
+	now := time.Now()
+	valid := jwtv5.MapClaims{
+		"iss":   "https://issuer.example.com",
+		"aud":   "gateway",
+		"sub":   "10001",
+		"exp":   now.Add(time.Minute).Unix(),
+		"scope": "users:read users:write",
+	}
+	with := func(k string, v any) jwtv5.MapClaims {
+		out := jwtv5.MapClaims{}
+		for name, value := range valid {
+			out[name] = value
+		}
+		out[k] = v
+		return out
+	}
+	without := func(k string) jwtv5.MapClaims {
+		out := with(k, nil)
+		delete(out, k)
+		return out
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		status int
+		userID string
+	}{
+		{"valid", sign(t, jwtv5.SigningMethodRS256, "k1", key, valid), http.StatusOK, "10001"},
+		{"missing", "", http.StatusUnauthorized, ""},
+		{"malformed", "a.b.c", http.StatusUnauthorized, ""},
+		{"expired", sign(t, jwtv5.SigningMethodRS256, "k1", key, with("exp", now.Add(-time.Minute).Unix())), http.StatusUnauthorized, ""},
+		{"expired within skew", sign(t, jwtv5.SigningMethodRS256, "k1", key, with("exp", now.Add(-10*time.Second).Unix())), http.StatusOK, "10001"},
+		{"no expiration", sign(t, jwtv5.SigningMethodRS256, "k1", key, without("exp")), http.StatusUnauthorized, ""},
+		{"not before", sign(t, jwtv5.SigningMethodRS256, "k1", key, with("nbf", now.Add(time.Minute).Unix())), http.StatusUnauthorized, ""},
+		{"issuer", sign(t, jwtv5.SigningMethodRS256, "k1", key, with("iss", "https://evil.example.com")), http.StatusUnauthorized, ""},
+		{"audience", sign(t, jwtv5.SigningMethodRS256, "k1", key, with("aud", "other")), http.StatusUnauthorized, ""},
+		{"scope", sign(t, jwtv5.SigningMethodRS256, "k1", key, with("scope", "users:write")), http.StatusForbidden, ""},
+		{"hs256 without secret", sign(t, jwtv5.SigningMethodHS256, "k1", []byte("secret"), valid), http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := roundTrip(t, tripper, tt.token)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+			if tt.status == http.StatusOK && resp.Header.Get("X-User-Id") != tt.userID {
+				t.Fatalf("X-User-Id = %q, want %q", resp.Header.Get("X-User-Id"), tt.userID)
+			}
+			if tt.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+				t.Fatal("missing WWW-Authenticate header")
+			}
+		})
+	}
+
+	// a token signed by a rotated key triggers a refresh
+	rotated := server.rotate(t, "k2")
+	unknown, _ := rsa.GenerateKey(rand.Reader, 2048)
+	time.Sleep(_minRefreshInterval)
+	if resp := roundTrip(t, tripper, sign(t, jwtv5.SigningMethodRS256, "k2", rotated, valid)); resp.StatusCode != http.StatusOK {
+		t.Fatalf("rotated key: status = %d", resp.StatusCode)
+	}
+	// unknown key ids do not hammer the jwks endpoint, the interval is
+	// widened not to depend on the speed of the round trips
+	_minRefreshInterval = time.Hour
+	token := sign(t, jwtv5.SigningMethodRS256, "k3", unknown, valid)
+	server.lock.Lock()
+	count := server.count
+	server.lock.Unlock()
+	for i := 0; i < 5; i++ {
+		roundTrip(t, tripper, token)
+	}
+	server.lock.Lock()
+	defer server.lock.Unlock()
+	if server.count != count {
+		t.Fatalf("jwks fetched %d times for unknown key ids", server.count-count)
+	}
+}
+
+func TestKeySetRegistry(t *testing.T) {
+	release := make(chan struct{})
+	server := newJWKSServer(t)
+	key := server.rotate(t, "k1")
+	handler := server.Config.Handler
+	server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		handler.ServeHTTP(w, r)
+	})
+
+	// the middlewares are created without waiting for the keys
+	start := time.Now()
+	var middlewares []middleware.MiddlewareV2
+	for i := 0; i < 2; i++ {
+		m, err := Middleware(&config.Middleware{Name: "jwt", Options: map[string]any{"jwks": map[string]any{"url": server.URL}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		middlewares = append(middlewares, m)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("expected the keys to be loaded in background, took %s", elapsed)
+	}
+	close(release)
+
+	// a request waits for the keys being loaded
+	token := sign(t, jwtv5.SigningMethodRS256, "k1", key, jwtv5.MapClaims{"exp": time.Now().Add(time.Minute).Unix()})
+	for _, m := range middlewares {
+		tripper := m.Process(middleware.RoundTripperFunc(func(*http.Request) (*http.Response, error) {
+			return httptest.NewRecorder().Result(), nil
+		}))
+		if resp := roundTrip(t, tripper, token); resp.StatusCode != http.StatusOK {
+			t.Fatalf("status = %d", resp.StatusCode)
+		}
+	}
+	server.lock.Lock()
+	count := server.count
+	server.lock.Unlock()
+	if count != 1 {
+		t.Fatalf("expected the middlewares to share a single fetch, got %d", count)
+	}
+
+	// the key set is dropped once its last middleware is closed
+	_ = middlewares[0].Close()
+	_ = middlewares[0].Close()
+	_keySetRegistry.lock.Lock()
+	_, ok := _keySetRegistry.sets[server.URL]
+	_keySetRegistry.lock.Unlock()
+	if !ok {
+		t.Fatal("expected the key set to be kept for the remaining middleware")
+	}
+	_ = middlewares[1].Close()
+	_keySetRegistry.lock.Lock()
+	_, ok = _keySetRegistry.sets[server.URL]
+	_keySetRegistry.lock.Unlock()
+	if ok {
+		t.Fatal("expected the key set to be released")
+	}
+}
+
+func TestStaticKeys(t *testing.T) {
+	claims := jwtv5.MapClaims{"sub": "10001", "exp": time.Now().Add(time.Minute).Unix()}
+
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	pemOf := func(pub any) string {
+		der, err := x509.MarshalPKIXPublicKey(pub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	}
+
+	tests := []struct {
+		name    string
+		options map[string]any
+		token   string
+		status  int
+	}{
+		{"hs256", map[string]any{"secret": "secret"}, sign(t, jwtv5.SigningMethodHS256, "", []byte("secret"), claims), http.StatusOK},
+		{"hs256 wrong secret", map[string]any{"secret": "secret"}, sign(t, jwtv5.SigningMethodHS256, "", []byte("other"), claims), http.StatusUnauthorized},
+		{"es256", map[string]any{"publicKey": pemOf(&ecKey.PublicKey)}, sign(t, jwtv5.SigningMethodES256, "", ecKey, claims), http.StatusOK},
+		{"eddsa", map[string]any{"publicKey": pemOf(edPub)}, sign(t, jwtv5.SigningMethodEdDSA, "", edKey, claims), http.StatusOK},
+		{"algorithm not allowed", map[string]any{"publicKey": pemOf(edPub), "algorithms": "RS256"}, sign(t, jwtv5.SigningMethodEdDSA, "", edKey, claims), http.StatusUnauthorized},
+		{"none", map[string]any{"secret": "secret"}, sign(t, jwtv5.SigningMethodNone, "", jwtv5.UnsafeAllowNoneSignatureType, claims), http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := roundTrip(t, newTestMiddleware(t, tt.options), tt.token)
+			if resp.StatusCode != tt.status {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.status)
+			}
+		})
+	}
+}
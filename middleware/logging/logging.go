@@ -36,6 +36,7 @@ func Middleware(c *config.Middleware) (middleware.Middleware, error) {
 			log.Context(ctx).Log(level,
 				"source", "accesslog",
 				"host", req.Host,
+				"client_ip", middleware.ClientIP(req),
 				"method", req.Method,
 				"scheme", req.URL.Scheme,
 				"path", req.URL.Path,
@@ -47,6 +48,8 @@ func Middleware(c *config.Middleware) (middleware.Middleware, error) {
 				"backend_code", reqOpt.UpstreamStatusCode,
 				"backend_latency", reqOpt.UpstreamResponseTime,
 				"last_attempt", reqOpt.LastAttempt,
+				"retry_delays", reqOpt.RetryDelays,
+				"consumer", reqOpt.Metadata["consumer"],
 				"trace", tracing.TraceID()(ctx),
 				"span", tracing.SpanID()(ctx),
 			)
@@ -0,0 +1,260 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/selector"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/limes-cloud/gateway/client"
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+const (
+	defaultProtocol       = "HTTP"
+	defaultPercentage     = 100
+	defaultMaxConcurrency = 64
+	defaultMaxBodySize    = 1 << 20
+	defaultTimeout        = 10 * time.Second
+)
+
+func Init(clientFactory client.Factory) {
+	middleware.RegisterV2("mirror", New(clientFactory))
+	prometheus.MustRegister(_metricMirrorTotal)
+	prometheus.MustRegister(_metricMirrorInflight)
+}
+
+var (
+	_metricMirrorTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "requests_mirror_total",
+		Help:      "The total number of mirrored requests",
+	}, []string{"protocol", "method", "path", "service", "basePath", "result"})
+	_metricMirrorInflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "requests_mirror_inflight",
+		Help:      "The number of in-flight mirrored requests",
+	}, []string{"protocol", "method", "path", "service", "basePath"})
+)
+
+func mirrorIncr(labels middleware.MetricsLabels, result string) {
+	_metricMirrorTotal.WithLabelValues(labels.Protocol(), labels.Method(), labels.Path(), labels.Service(), labels.BasePath(), result).Inc()
+}
+
+func inflightAdd(labels middleware.MetricsLabels, delta float64) {
+	_metricMirrorInflight.WithLabelValues(labels.Protocol(), labels.Method(), labels.Path(), labels.Service(), labels.BasePath()).Add(delta)
+}
+
+type mirror struct {
+	options  *config.Mirror
+	endpoint *config.Endpoint
+	client   client.Client
+	inflight chan struct{}
+	// lock makes the check and the mark of a mirrored request atomic,
+	// the hedged attempts of a request may run concurrently.
+	lock sync.Mutex
+}
+
+// mirroredKey marks the request already handled by the mirror, the values are
+// shared by the retries and the hedged attempts of the request.
+type mirroredKey struct {
+	m *mirror
+}
+
+// first reports whether the request is seen by the mirror for the first time.
+func (m *mirror) first(reqOpt *middleware.RequestOptions) bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := reqOpt.Values.Get(mirroredKey{m}); ok {
+		return false
+	}
+	reqOpt.Values.Set(mirroredKey{m}, struct{}{})
+	return true
+}
+
+// readBody returns a copy of the buffered request body.
+// ok is false if the body is larger than the max body size.
+func (m *mirror) readBody(req *http.Request) ([]byte, bool) {
+	body, err := req.GetBody()
+	if err != nil {
+		return nil, false
+	}
+	defer body.Close()
+	data, err := io.ReadAll(io.LimitReader(body, m.options.MaxBodySize+1))
+	if err != nil || int64(len(data)) > m.options.MaxBodySize {
+		return nil, false
+	}
+	return data, true
+}
+
+// teeBody copies the streamed body while the upstream reads it, done is called
+// once the body has been read to the end or closed before.
+type teeBody struct {
+	io.ReadCloser
+	buf      bytes.Buffer
+	limit    int64
+	overflow bool
+	once     sync.Once
+	done     func(body []byte, ok bool)
+}
+
+func (b *teeBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	if n > 0 && !b.overflow {
+		if int64(b.buf.Len()+n) > b.limit {
+			b.overflow = true
+			b.buf = bytes.Buffer{}
+		} else {
+			b.buf.Write(p[:n])
+		}
+	}
+	if err == io.EOF {
+		b.once.Do(func() { b.done(b.buf.Bytes(), !b.overflow) })
+	}
+	return n, err
+}
+
+func (b *teeBody) Close() error {
+	// a body closed before its end is not mirrored
+	b.once.Do(func() { b.done(nil, false) })
+	return b.ReadCloser.Close()
+}
+
+func (m *mirror) send(req *http.Request) {
+	reqOpt, ok := middleware.FromRequestContext(req.Context())
+	if !ok || !m.first(reqOpt) {
+		// retries and hedged attempts are not mirrored again
+		return
+	}
+	labels := middleware.NewMetricsLabels(reqOpt.Endpoint)
+	if rand.Float64()*100 >= m.options.Percentage {
+		return
+	}
+	select {
+	case m.inflight <- struct{}{}:
+	default:
+		mirrorIncr(labels, "dropped")
+		return
+	}
+	skip := func() {
+		<-m.inflight
+		mirrorIncr(labels, "skipped")
+	}
+	if req.ContentLength > m.options.MaxBodySize {
+		skip()
+		return
+	}
+
+	reqOpts := middleware.NewRequestOptions(m.endpoint)
+	mirrorReq := req.Clone(middleware.NewRequestContext(context.Background(), reqOpts))
+	mirrorReq.GetBody = nil
+	switch {
+	case req.Body == nil || req.Body == http.NoBody || req.ContentLength == 0:
+		m.dispatch(mirrorReq, reqOpts, labels, nil)
+	case req.GetBody != nil:
+		body, ok := m.readBody(req)
+		if !ok {
+			skip()
+			return
+		}
+		m.dispatch(mirrorReq, reqOpts, labels, body)
+	default:
+		// the streamed body is not buffered ahead of the upstream, the mirror
+		// is sent once the upstream has read the whole body.
+		req.Body = &teeBody{ReadCloser: req.Body, limit: m.options.MaxBodySize, done: func(body []byte, ok bool) {
+			if !ok {
+				skip()
+				return
+			}
+			m.dispatch(mirrorReq, reqOpts, labels, body)
+		}}
+	}
+}
+
+// dispatch sends the mirrored request in the background, the in-flight slot
+// is released when it is done.
+func (m *mirror) dispatch(mirrorReq *http.Request, reqOpts *middleware.RequestOptions, labels middleware.MetricsLabels, body []byte) {
+	ctx, cancel := context.WithTimeout(mirrorReq.Context(), m.options.Timeout)
+	mirrorReq = mirrorReq.WithContext(ctx)
+	mirrorReq.ContentLength = int64(len(body))
+	mirrorReq.Body = http.NoBody
+	if len(body) > 0 {
+		mirrorReq.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	inflightAdd(labels, 1)
+	go func() {
+		defer func() {
+			cancel()
+			inflightAdd(labels, -1)
+			<-m.inflight
+		}()
+		resp, err := m.client.RoundTrip(mirrorReq)
+		if err != nil {
+			mirrorIncr(labels, "failed")
+			log.Warnf("Failed to mirror request: %s to %s: %+v", mirrorReq.URL.Path, m.options.Target, err)
+			return
+		}
+		_, err = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		reqOpts.DoneFunc(ctx, selector.DoneInfo{Err: err, ReplyMD: resp.Trailer})
+		mirrorIncr(labels, "success")
+	}()
+}
+
+func New(factory client.Factory) middleware.FactoryV2 {
+	return func(c *config.Middleware) (middleware.MiddlewareV2, error) {
+		options := &config.Mirror{
+			Protocol:       defaultProtocol,
+			Percentage:     defaultPercentage,
+			MaxConcurrency: defaultMaxConcurrency,
+			MaxBodySize:    defaultMaxBodySize,
+			Timeout:        defaultTimeout,
+		}
+		if c.Options != nil {
+			if err := utils.Copy(c.Options, options); err != nil {
+				return nil, err
+			}
+		}
+		if options.Target == "" {
+			return nil, errors.New("mirror target is required")
+		}
+		if options.MaxConcurrency <= 0 {
+			options.MaxConcurrency = defaultMaxConcurrency
+		}
+		endpoint := &config.Endpoint{
+			Protocol: options.Protocol,
+			Timeout:  options.Timeout,
+			Backends: []config.Backend{{Target: options.Target}},
+		}
+		mirrorClient, err := factory(endpoint)
+		if err != nil {
+			return nil, err
+		}
+		m := &mirror{
+			options:  options,
+			endpoint: endpoint,
+			client:   mirrorClient,
+			inflight: make(chan struct{}, options.MaxConcurrency),
+		}
+		return middleware.NewWithCloser(func(next http.RoundTripper) http.RoundTripper {
+			return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				// the mirror never affects the primary request
+				m.send(req)
+				return next.RoundTrip(req)
+			})
+		}, mirrorClient), nil
+	}
+}
@@ -0,0 +1,177 @@
+package mirror
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/limes-cloud/gateway/client"
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+// upstream records the bodies of the mirrored requests, release blocks them when set.
+type upstream struct {
+	bodies  chan string
+	release chan struct{}
+}
+
+func (u *upstream) RoundTrip(req *http.Request) (*http.Response, error) {
+	b, _ := io.ReadAll(req.Body)
+	if u.release != nil {
+		<-u.release
+	}
+	u.bodies <- string(b)
+	return httptest.NewRecorder().Result(), nil
+}
+
+func (u *upstream) Close() error { return nil }
+
+func newMirror(t *testing.T, u *upstream, options map[string]any) http.RoundTripper {
+	t.Helper()
+	options["target"] = "127.0.0.1:1"
+	factory := func(*config.Endpoint) (client.Client, error) { return u, nil }
+	m, err := New(factory)(&config.Middleware{Name: "mirror", Options: options})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the primary upstream reads the whole body
+	return m.Process(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.Body != nil {
+			_, _ = io.ReadAll(req.Body)
+			req.Body.Close()
+		}
+		return httptest.NewRecorder().Result(), nil
+	}))
+}
+
+func newRequest(body io.Reader, buffered bool) (*http.Request, *middleware.RequestOptions) {
+	req := httptest.NewRequest(http.MethodPost, "/mirror", body)
+	if buffered {
+		data, _ := io.ReadAll(req.Body)
+		req.Body = io.NopCloser(bytes.NewReader(data))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return io.NopCloser(bytes.NewReader(data)), nil
+		}
+	} else {
+		req.GetBody = nil
+	}
+	reqOpt := middleware.NewRequestOptions(&config.Endpoint{Path: "/mirror"})
+	return req.WithContext(middleware.NewRequestContext(context.Background(), reqOpt)), reqOpt
+}
+
+func roundTrip(t *testing.T, rt http.RoundTripper, req *http.Request) {
+	t.Helper()
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+}
+
+func expectMirrored(t *testing.T, u *upstream, expected string) {
+	t.Helper()
+	select {
+	case got := <-u.bodies:
+		if got != expected {
+			t.Fatalf("expected the mirrored body %q, got %q", expected, got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the request to be mirrored")
+	}
+}
+
+func expectNotMirrored(t *testing.T, u *upstream) {
+	t.Helper()
+	select {
+	case got := <-u.bodies:
+		t.Fatalf("expected no mirrored request, got %q", got)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMirrorBody(t *testing.T) {
+	u := &upstream{bodies: make(chan string, 10)}
+	rt := newMirror(t, u, map[string]any{"maxBodySize": 8})
+
+	req, _ := newRequest(strings.NewReader("buffered"), true)
+	roundTrip(t, rt, req)
+	expectMirrored(t, u, "buffered")
+
+	req, _ = newRequest(strings.NewReader("streamed"), false)
+	roundTrip(t, rt, req)
+	expectMirrored(t, u, "streamed")
+
+	// the streamed body is not read ahead of the upstream
+	pr, pw := io.Pipe()
+	req, _ = newRequest(pr, false)
+	req.ContentLength = -1
+	go func() {
+		_, _ = pw.Write([]byte("chunk"))
+		pw.Close()
+	}()
+	roundTrip(t, rt, req)
+	expectMirrored(t, u, "chunk")
+
+	req, _ = newRequest(strings.NewReader("too large body"), true)
+	roundTrip(t, rt, req)
+	req, _ = newRequest(strings.NewReader("too large body"), false)
+	req.ContentLength = -1
+	roundTrip(t, rt, req)
+	expectNotMirrored(t, u)
+}
+
+func TestMirrorOnce(t *testing.T) {
+	u := &upstream{bodies: make(chan string, 10)}
+	rt := newMirror(t, u, map[string]any{})
+	req, reqOpt := newRequest(strings.NewReader("body"), true)
+	roundTrip(t, rt, req)
+	// a retry and a hedged attempt of the same request
+	roundTrip(t, rt, req)
+	attempt := middleware.NewAttemptOptions(reqOpt)
+	roundTrip(t, rt, req.WithContext(middleware.NewRequestContext(context.Background(), attempt)))
+	expectMirrored(t, u, "body")
+	expectNotMirrored(t, u)
+}
+
+func TestMirrorSampling(t *testing.T) {
+	u := &upstream{bodies: make(chan string, 100)}
+	rt := newMirror(t, u, map[string]any{"percentage": 0})
+	for i := 0; i < 10; i++ {
+		req, _ := newRequest(http.NoBody, true)
+		roundTrip(t, rt, req)
+	}
+	expectNotMirrored(t, u)
+
+	rt = newMirror(t, u, map[string]any{"percentage": 50})
+	for i := 0; i < 100; i++ {
+		req, _ := newRequest(http.NoBody, true)
+		roundTrip(t, rt, req)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if n := len(u.bodies); n == 0 || n == 100 {
+		t.Fatalf("expected about half of the requests to be mirrored, got %d", n)
+	}
+}
+
+func TestMirrorConcurrency(t *testing.T) {
+	u := &upstream{bodies: make(chan string, 10), release: make(chan struct{})}
+	rt := newMirror(t, u, map[string]any{"maxConcurrency": 1})
+	for _, body := range []string{"first", "second", "third"} {
+		req, _ := newRequest(strings.NewReader(body), true)
+		roundTrip(t, rt, req)
+	}
+	// the requests over the limit are dropped while the first one is in flight
+	close(u.release)
+	expectMirrored(t, u, "first")
+	expectNotMirrored(t, u)
+
+	req, _ := newRequest(strings.NewReader("fourth"), true)
+	roundTrip(t, rt, req)
+	expectMirrored(t, u, "fourth")
+}
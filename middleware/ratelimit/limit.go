@@ -0,0 +1,141 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+const (
+	algorithmTokenBucket   = "token_bucket"
+	algorithmSlidingWindow = "sliding_window"
+)
+
+// Result is the decision of a limiter for one request.
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is the number of requests still allowed right now.
+	Remaining int
+	// RetryAfter is the time to wait before the next request is allowed.
+	RetryAfter time.Duration
+	// Reset is the time until the limit is fully restored.
+	Reset time.Duration
+}
+
+// Store keeps the limiter state of every key.
+type Store interface {
+	Allow(ctx context.Context, key string, now time.Time) (Result, error)
+	Close() error
+}
+
+// limit describes the limit applied to every key.
+type limit struct {
+	algorithm string
+	// rate is the number of requests allowed per period.
+	rate   int
+	period time.Duration
+	// burst is the capacity of the token bucket.
+	burst int
+}
+
+// refill returns the tokens added to the bucket per nanosecond.
+func (l *limit) refill() float64 {
+	return float64(l.rate) / float64(l.period)
+}
+
+// takeToken refills the bucket for the elapsed time and takes a token if any.
+func (l *limit) takeToken(tokens float64, elapsed time.Duration) (float64, bool) {
+	if elapsed > 0 {
+		tokens = math.Min(float64(l.burst), tokens+float64(elapsed)*l.refill())
+	}
+	if tokens >= 1 {
+		return tokens - 1, true
+	}
+	return tokens, false
+}
+
+func (l *limit) bucketResult(tokens float64, allowed bool) Result {
+	r := Result{
+		Allowed:   allowed,
+		Limit:     l.burst,
+		Remaining: int(math.Floor(tokens)),
+		Reset:     time.Duration(math.Ceil((float64(l.burst) - tokens) / l.refill())),
+	}
+	if !allowed {
+		r.RetryAfter = time.Duration(math.Ceil((1 - tokens) / l.refill()))
+	}
+	return r
+}
+
+// window is the state of a sliding window counter: the requests of the
+// current and of the previous fixed window, weighted by their overlap.
+type window struct {
+	start    int64
+	current  float64
+	previous float64
+}
+
+// slide moves the window to the one of now.
+func (l *limit) slide(w window, now time.Time) window {
+	start := now.UnixNano() / int64(l.period)
+	switch {
+	case start == w.start:
+	case start == w.start+1:
+		w = window{start: start, previous: w.current}
+	default:
+		w = window{start: start}
+	}
+	return w
+}
+
+func (l *limit) estimate(w window, now time.Time) (float64, time.Duration) {
+	elapsed := time.Duration(now.UnixNano() - w.start*int64(l.period))
+	return w.previous*float64(l.period-elapsed)/float64(l.period) + w.current, elapsed
+}
+
+func (l *limit) windowResult(w window, now time.Time, allowed bool) Result {
+	estimated, elapsed := l.estimate(w, now)
+	r := Result{
+		Allowed:   allowed,
+		Limit:     l.rate,
+		Remaining: int(math.Max(0, math.Floor(float64(l.rate)-estimated))),
+		// the previous window is forgotten once the next window starts
+		Reset: 2*l.period - elapsed,
+	}
+	if w.current == 0 && w.previous == 0 {
+		r.Reset = 0
+	}
+	if !allowed {
+		// wait for the weight of the previous window to make room for one request,
+		// or for the next window if the current one is full on its own
+		room := float64(l.rate) - 1 - w.current
+		if w.previous > 0 && room >= 0 {
+			r.RetryAfter = time.Duration(math.Ceil(float64(l.period)*(1-room/w.previous))) - elapsed
+		} else {
+			r.RetryAfter = l.period - elapsed
+		}
+		if r.RetryAfter <= 0 {
+			r.RetryAfter = time.Millisecond
+		}
+	}
+	return r
+}
+
+// take counts the request in the window if the limit allows it.
+func (l *limit) take(w window, now time.Time) (window, bool) {
+	estimated, _ := l.estimate(w, now)
+	if estimated+1 > float64(l.rate) {
+		return w, false
+	}
+	w.current++
+	return w, true
+}
+
+// ttl is the time after which an untouched key is back to its initial state.
+func (l *limit) ttl() time.Duration {
+	if l.algorithm == algorithmSlidingWindow {
+		return 2 * l.period
+	}
+	return time.Duration(math.Ceil(float64(l.burst) / l.refill()))
+}
@@ -0,0 +1,104 @@
+package ratelimit
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+)
+
+const (
+	_defaultShards = 32
+	_sweepInterval = time.Minute
+)
+
+type entry struct {
+	tokens   float64
+	window   window
+	lastSeen time.Time
+}
+
+type shard struct {
+	lock    sync.Mutex
+	entries map[string]*entry
+}
+
+// memoryStore keeps the state in shards to reduce lock contention,
+// idle keys are swept in background.
+type memoryStore struct {
+	limit     *limit
+	shards    []*shard
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+func newMemoryStore(l *limit, shards int) *memoryStore {
+	if shards <= 0 {
+		shards = _defaultShards
+	}
+	s := &memoryStore{
+		limit:  l,
+		shards: make([]*shard, shards),
+		done:   make(chan struct{}),
+	}
+	for i := range s.shards {
+		s.shards[i] = &shard{entries: map[string]*entry{}}
+	}
+	go s.sweep()
+	return s
+}
+
+func (s *memoryStore) shard(key string) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return s.shards[h.Sum32()%uint32(len(s.shards))]
+}
+
+func (s *memoryStore) Allow(_ context.Context, key string, now time.Time) (Result, error) {
+	sh := s.shard(key)
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+	e, ok := sh.entries[key]
+	if !ok {
+		e = &entry{tokens: float64(s.limit.burst), lastSeen: now}
+		sh.entries[key] = e
+	}
+	var allowed bool
+	if s.limit.algorithm == algorithmSlidingWindow {
+		e.window, allowed = s.limit.take(s.limit.slide(e.window, now), now)
+		e.lastSeen = now
+		return s.limit.windowResult(e.window, now, allowed), nil
+	}
+	e.tokens, allowed = s.limit.takeToken(e.tokens, now.Sub(e.lastSeen))
+	e.lastSeen = now
+	return s.limit.bucketResult(e.tokens, allowed), nil
+}
+
+func (s *memoryStore) sweep() {
+	ticker := time.NewTicker(_sweepInterval)
+	defer ticker.Stop()
+	ttl := s.limit.ttl()
+	for {
+		select {
+		case <-s.done:
+			return
+		case now := <-ticker.C:
+			for _, sh := range s.shards {
+				sh.lock.Lock()
+				for key, e := range sh.entries {
+					if now.Sub(e.lastSeen) > ttl {
+						delete(sh.entries, key)
+					}
+				}
+				sh.lock.Unlock()
+			}
+		}
+	}
+}
+
+func (s *memoryStore) Close() error {
+	s.closeOnce.Do(func() {
+		close(s.done)
+	})
+	return nil
+}
@@ -0,0 +1,223 @@
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/middleware/jwt"
+	"github.com/limes-cloud/gateway/proxy/render"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+const (
+	storeMemory = "memory"
+	storeRedis  = "redis"
+
+	keyIP     = "ip"
+	keyAPIKey = "apikey"
+	keyRoute  = "route"
+	keyHeader = "header:"
+	keyClaim  = "claim:"
+
+	_defaultPeriod = time.Second
+)
+
+func init() {
+	middleware.RegisterV2("ratelimit", Middleware)
+}
+
+// allowedKey marks a request already counted, so that its retries are not.
+type allowedKey struct{}
+
+type RateLimit struct {
+	Algorithm  string
+	Rate       int
+	Period     time.Duration
+	Burst      int
+	Key        string
+	Store      string
+	Redis      *Redis
+	FailClosed bool
+	Shards     int
+}
+
+// keyFunc returns a part of the limiter key of the request.
+type keyFunc func(req *http.Request, reqOpt *middleware.RequestOptions) (string, bool)
+
+func parseKey(key string) ([]keyFunc, error) {
+	if key == "" {
+		key = keyIP
+	}
+	var fns []keyFunc
+	for _, part := range strings.Split(key, ",") {
+		part = strings.TrimSpace(part)
+		switch {
+		case part == keyIP:
+			fns = append(fns, func(req *http.Request, _ *middleware.RequestOptions) (string, bool) {
+				return middleware.ClientIP(req), true
+			})
+		case part == keyAPIKey:
+			fns = append(fns, func(_ *http.Request, reqOpt *middleware.RequestOptions) (string, bool) {
+				if reqOpt == nil || reqOpt.Metadata["consumer"] == "" {
+					return "", false
+				}
+				return reqOpt.Metadata["consumer"], true
+			})
+		case part == keyRoute:
+			fns = append(fns, func(req *http.Request, reqOpt *middleware.RequestOptions) (string, bool) {
+				if reqOpt == nil || reqOpt.Endpoint == nil {
+					return req.Method + " " + req.URL.Path, true
+				}
+				return reqOpt.Endpoint.Method + " " + reqOpt.Endpoint.Path, true
+			})
+		case strings.HasPrefix(part, keyHeader) && len(part) > len(keyHeader):
+			name := part[len(keyHeader):]
+			fns = append(fns, func(req *http.Request, _ *middleware.RequestOptions) (string, bool) {
+				v := req.Header.Get(name)
+				return v, v != ""
+			})
+		case strings.HasPrefix(part, keyClaim) && len(part) > len(keyClaim):
+			name := part[len(keyClaim):]
+			fns = append(fns, func(req *http.Request, _ *middleware.RequestOptions) (string, bool) {
+				claims, ok := jwt.FromContext(req.Context())
+				if !ok {
+					return "", false
+				}
+				return jwt.ClaimString(claims, name)
+			})
+		default:
+			return nil, fmt.Errorf("unknown ratelimit key: %s", part)
+		}
+	}
+	return fns, nil
+}
+
+// requestKey joins the key parts of the request, the client ip
+// is used instead when any of them is missing.
+func requestKey(fns []keyFunc, req *http.Request, reqOpt *middleware.RequestOptions) string {
+	parts := make([]string, 0, len(fns))
+	for _, fn := range fns {
+		v, ok := fn(req, reqOpt)
+		if !ok {
+			return keyIP + ":" + middleware.ClientIP(req)
+		}
+		parts = append(parts, v)
+	}
+	return strings.Join(parts, "|")
+}
+
+func newLimit(options *RateLimit) (*limit, error) {
+	l := &limit{
+		algorithm: options.Algorithm,
+		rate:      options.Rate,
+		period:    options.Period,
+		burst:     options.Burst,
+	}
+	if l.algorithm == "" {
+		l.algorithm = algorithmTokenBucket
+	}
+	if l.algorithm != algorithmTokenBucket && l.algorithm != algorithmSlidingWindow {
+		return nil, fmt.Errorf("unknown ratelimit algorithm: %s", l.algorithm)
+	}
+	if l.rate <= 0 {
+		return nil, fmt.Errorf("ratelimit requires a positive rate")
+	}
+	if l.period <= 0 {
+		l.period = _defaultPeriod
+	}
+	if l.burst <= 0 {
+		l.burst = l.rate
+	}
+	return l, nil
+}
+
+func newStore(l *limit, options *RateLimit) (Store, error) {
+	switch options.Store {
+	case "", storeMemory:
+		return newMemoryStore(l, options.Shards), nil
+	case storeRedis:
+		return newRedisStore(l, options.Redis)
+	}
+	return nil, fmt.Errorf("unknown ratelimit store: %s", options.Store)
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+}
+
+func setHeaders(header http.Header, r Result) {
+	header.Set("X-RateLimit-Limit", strconv.Itoa(r.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(r.Remaining))
+	header.Set("X-RateLimit-Reset", seconds(r.Reset))
+}
+
+// Middleware limits the requests of every client key with a token bucket
+// or a sliding window, the state is kept in memory or shared through redis.
+func Middleware(c *config.Middleware) (middleware.MiddlewareV2, error) {
+	options := &RateLimit{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	l, err := newLimit(options)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseKey(options.Key)
+	if err != nil {
+		return nil, err
+	}
+	store, err := newStore(l, options)
+	if err != nil {
+		return nil, err
+	}
+	return middleware.NewWithCloser(func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			reqOpt, ok := middleware.FromRequestContext(req.Context())
+			if ok {
+				if v, allowed := reqOpt.Values.Get(allowedKey{}); allowed {
+					resp, err := next.RoundTrip(req)
+					if err != nil {
+						return nil, err
+					}
+					setHeaders(resp.Header, v.(Result))
+					return resp, nil
+				}
+			} else {
+				reqOpt = nil
+			}
+			result, err := store.Allow(req.Context(), requestKey(keys, req, reqOpt), time.Now())
+			if err != nil {
+				log.Errorf("ratelimit store error: %v", err)
+				if options.FailClosed {
+					return render.NewResponse(req, render.NewError(http.StatusServiceUnavailable, "rate limiter unavailable")), nil
+				}
+				return next.RoundTrip(req)
+			}
+			if !result.Allowed {
+				resp := render.NewResponse(req, render.NewError(http.StatusTooManyRequests, "rate limit exceeded"))
+				setHeaders(resp.Header, result)
+				resp.Header.Set("Retry-After", seconds(result.RetryAfter))
+				return resp, nil
+			}
+			if reqOpt != nil {
+				reqOpt.Values.Set(allowedKey{}, result)
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil {
+				return nil, err
+			}
+			setHeaders(resp.Header, result)
+			return resp, nil
+		})
+	}, store), nil
+}
@@ -0,0 +1,173 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+func testStores(t *testing.T, options *RateLimit) map[string]Store {
+	l, err := newLimit(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mr := miniredis.RunT(t)
+	rs, err := newRedisStore(l, &Redis{Addr: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms := newMemoryStore(l, 4)
+	t.Cleanup(func() {
+		rs.Close()
+		ms.Close()
+	})
+	return map[string]Store{storeMemory: ms, storeRedis: rs}
+}
+
+func allow(t *testing.T, s Store, key string, now time.Time) Result {
+	r, err := s.Allow(context.Background(), key, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestTokenBucket(t *testing.T) {
+	for name, s := range testStores(t, &RateLimit{Rate: 2, Period: time.Second, Burst: 4}) {
+		t.Run(name, func(t *testing.T) {
+			now := time.UnixMilli(1700000000000)
+			for i := 0; i < 4; i++ {
+				if r := allow(t, s, "a", now); !r.Allowed || r.Remaining != 3-i || r.Limit != 4 {
+					t.Fatalf("request %d: %+v", i, r)
+				}
+			}
+			r := allow(t, s, "a", now)
+			if r.Allowed || r.RetryAfter != 500*time.Millisecond || r.Reset != 2*time.Second {
+				t.Fatalf("expected limited: %+v", r)
+			}
+			if r := allow(t, s, "b", now); !r.Allowed {
+				t.Fatalf("keys must be limited separately: %+v", r)
+			}
+			if r := allow(t, s, "a", now.Add(500*time.Millisecond)); !r.Allowed || r.Remaining != 0 {
+				t.Fatalf("expected a refilled token: %+v", r)
+			}
+			if r := allow(t, s, "a", now.Add(time.Minute)); !r.Allowed || r.Remaining != 3 {
+				t.Fatalf("refill must be capped by the burst: %+v", r)
+			}
+		})
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	for name, s := range testStores(t, &RateLimit{Algorithm: algorithmSlidingWindow, Rate: 4, Period: time.Second}) {
+		t.Run(name, func(t *testing.T) {
+			start := time.UnixMilli(1700000000000)
+			for i := 0; i < 4; i++ {
+				if r := allow(t, s, "a", start); !r.Allowed || r.Remaining != 3-i {
+					t.Fatalf("request %d: %+v", i, r)
+				}
+			}
+			r := allow(t, s, "a", start.Add(500*time.Millisecond))
+			if r.Allowed || r.RetryAfter != 500*time.Millisecond {
+				t.Fatalf("expected limited: %+v", r)
+			}
+			// a quarter of the next window: the previous one still weighs 3 requests
+			if r := allow(t, s, "a", start.Add(1250*time.Millisecond)); !r.Allowed || r.Remaining != 0 {
+				t.Fatalf("expected allowed: %+v", r)
+			}
+			if r := allow(t, s, "a", start.Add(1250*time.Millisecond)); r.Allowed {
+				t.Fatalf("expected limited: %+v", r)
+			}
+			if r := allow(t, s, "a", start.Add(5*time.Second)); !r.Allowed || r.Remaining != 3 {
+				t.Fatalf("expected a fresh window: %+v", r)
+			}
+		})
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	fns, err := parseKey("header:X-Tenant, route")
+	if err != nil {
+		t.Fatal(err)
+	}
+	reqOpt := &middleware.RequestOptions{Endpoint: &config.Endpoint{Method: "GET", Path: "/users/{id}"}}
+	req := httptest.NewRequest(http.MethodGet, "/users/1", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	if key := requestKey(fns, req, reqOpt); key != "ip:10.0.0.1" {
+		t.Fatalf("missing header must fall back to the ip, got %s", key)
+	}
+	req.Header.Set("X-Tenant", "acme")
+	if key := requestKey(fns, req, reqOpt); key != "acme|GET /users/{id}" {
+		t.Fatalf("unexpected key %s", key)
+	}
+	if _, err := parseKey("cookie:a"); err == nil {
+		t.Fatal("expected unknown key error")
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	m, err := Middleware(&config.Middleware{Name: "ratelimit", Options: map[string]any{
+		"rate":   1,
+		"period": "10s",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	rt := m.Process(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		return httptest.NewRecorder().Result(), nil
+	}))
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-RateLimit-Limit") != "1" ||
+		resp.Header.Get("X-RateLimit-Remaining") != "0" || resp.Header.Get("X-RateLimit-Reset") != "10" {
+		t.Fatalf("unexpected response: %d %v", resp.StatusCode, resp.Header)
+	}
+	resp, err = rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("unexpected response: %d %v", resp.StatusCode, resp.Header)
+	}
+}
+
+func TestRedisFailOpen(t *testing.T) {
+	for _, failClosed := range []bool{false, true} {
+		m, err := Middleware(&config.Middleware{Name: "ratelimit", Options: map[string]any{
+			"rate":       1,
+			"store":      storeRedis,
+			"redis":      map[string]any{"addr": "127.0.0.1:1", "timeout": "50ms"},
+			"failClosed": failClosed,
+		}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		rt := m.Process(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			return httptest.NewRecorder().Result(), nil
+		}))
+		resp, err := rt.RoundTrip(httptest.NewRequest(http.MethodGet, "/", nil))
+		m.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := http.StatusOK
+		if failClosed {
+			expected = http.StatusServiceUnavailable
+		}
+		if resp.StatusCode != expected {
+			t.Fatalf("failClosed=%v: expected %d, got %d", failClosed, expected, resp.StatusCode)
+		}
+	}
+}
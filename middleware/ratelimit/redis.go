@@ -0,0 +1,164 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	_defaultRedisPrefix  = "gateway:ratelimit:"
+	_defaultRedisTimeout = 100 * time.Millisecond
+)
+
+// The scripts keep the state of a key in a hash, so that every gateway instance
+// shares the same limit. The time is given by the caller in milliseconds.
+var (
+	tokenBucketScript = redis.NewScript(`
+local burst = tonumber(ARGV[1])
+local refill = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local ttl = tonumber(ARGV[4])
+local state = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+if now > ts then
+	tokens = math.min(burst, tokens + (now - ts) * refill)
+	ts = now
+end
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', tostring(ts))
+redis.call('PEXPIRE', KEYS[1], ttl)
+return {allowed, tostring(tokens)}
+`)
+
+	slidingWindowScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local start = math.floor(now / period)
+local state = redis.call('HMGET', KEYS[1], 'w', 'c', 'p')
+local w = tonumber(state[1])
+local c = tonumber(state[2]) or 0
+local p = tonumber(state[3]) or 0
+if w == nil or start > w + 1 then
+	p = 0
+	c = 0
+	w = start
+elseif start == w + 1 then
+	p = c
+	c = 0
+	w = start
+end
+local allowed = 0
+local elapsed = now - w * period
+if p * (period - elapsed) / period + c + 1 <= rate then
+	c = c + 1
+	allowed = 1
+end
+redis.call('HSET', KEYS[1], 'w', tostring(w), 'c', tostring(c), 'p', tostring(p))
+redis.call('PEXPIRE', KEYS[1], 2 * period)
+return {allowed, tostring(w), tostring(c), tostring(p)}
+`)
+)
+
+type Redis struct {
+	Addr     string
+	Password string
+	DB       int
+	Prefix   string
+	Timeout  time.Duration
+}
+
+// redisStore shares the limits of the gateway instances through redis.
+type redisStore struct {
+	limit   *limit
+	client  *redis.Client
+	prefix  string
+	timeout time.Duration
+}
+
+func newRedisStore(l *limit, options *Redis) (*redisStore, error) {
+	if options == nil || options.Addr == "" {
+		return nil, errors.New("ratelimit redis store requires an address")
+	}
+	if l.period%time.Millisecond != 0 {
+		return nil, errors.New("ratelimit redis store requires a period in whole milliseconds")
+	}
+	s := &redisStore{
+		limit:   l,
+		prefix:  options.Prefix,
+		timeout: options.Timeout,
+	}
+	if s.prefix == "" {
+		s.prefix = _defaultRedisPrefix
+	}
+	if s.timeout <= 0 {
+		s.timeout = _defaultRedisTimeout
+	}
+	s.client = redis.NewClient(&redis.Options{
+		Addr:     options.Addr,
+		Password: options.Password,
+		DB:       options.DB,
+	})
+	return s, nil
+}
+
+func (s *redisStore) Allow(ctx context.Context, key string, now time.Time) (Result, error) {
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	nowMs := now.UnixMilli()
+	if s.limit.algorithm == algorithmSlidingWindow {
+		values, err := s.run(ctx, slidingWindowScript, key, s.limit.rate, s.limit.period.Milliseconds(), nowMs)
+		if err != nil {
+			return Result{}, err
+		}
+		if len(values) != 4 {
+			return Result{}, fmt.Errorf("unexpected ratelimit script result: %v", values)
+		}
+		start, _ := strconv.ParseInt(values[1], 10, 64)
+		current, _ := strconv.ParseFloat(values[2], 64)
+		previous, _ := strconv.ParseFloat(values[3], 64)
+		w := window{start: start, current: current, previous: previous}
+		return s.limit.windowResult(w, now, values[0] == "1"), nil
+	}
+	refill := strconv.FormatFloat(s.limit.refill()*float64(time.Millisecond), 'g', -1, 64)
+	values, err := s.run(ctx, tokenBucketScript, key, s.limit.burst, refill, nowMs, s.limit.ttl().Milliseconds()+1)
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 2 {
+		return Result{}, fmt.Errorf("unexpected ratelimit script result: %v", values)
+	}
+	tokens, _ := strconv.ParseFloat(values[1], 64)
+	return s.limit.bucketResult(tokens, values[0] == "1"), nil
+}
+
+// run evaluates the script and returns its result as strings.
+func (s *redisStore) run(ctx context.Context, script *redis.Script, key string, args ...any) ([]string, error) {
+	res, err := script.Run(ctx, s.client, []string{s.prefix + key}, args...).Slice()
+	if err != nil {
+		return nil, err
+	}
+	values := make([]string, 0, len(res))
+	for _, v := range res {
+		values = append(values, fmt.Sprint(v))
+	}
+	return values, nil
+}
+
+func (s *redisStore) Close() error {
+	return s.client.Close()
+}
@@ -2,7 +2,12 @@ package middleware
 
 import (
 	"context"
+	"net/http"
+	"sync"
+	"time"
+
 	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/utils/clientip"
 
 	"github.com/go-kratos/kratos/v2/selector"
 )
@@ -17,10 +22,19 @@ type RequestOptions struct {
 	Metadata             map[string]string
 	UpstreamStatusCode   []int
 	UpstreamResponseTime []float64
+	RetryDelays          []time.Duration
 	CurrentNode          selector.Node
 	DoneFunc             selector.DoneFunc
 	LastAttempt          bool
-	Values               RequestValues
+	// ClientIP is the client address resolved behind the trusted proxies.
+	ClientIP string
+	Values   RequestValues
+	// OnSelected is called with the backend picked by the selector,
+	// it may be called from concurrent attempts of the same request.
+	OnSelected func(backend string)
+	// Signer is called by the client right before the request is sent,
+	// once the upstream host has been selected.
+	Signer func(req *http.Request) error
 }
 
 type RequestValues interface {
@@ -28,15 +42,23 @@ type RequestValues interface {
 	Set(key, val any)
 }
 
-type requestValues map[any]any
+// requestValues is shared by the concurrent attempts of a hedged request.
+type requestValues struct {
+	lock   sync.RWMutex
+	values map[any]any
+}
 
-func (v requestValues) Get(key any) (any, bool) {
-	val, ok := v[key]
+func (v *requestValues) Get(key any) (any, bool) {
+	v.lock.RLock()
+	defer v.lock.RUnlock()
+	val, ok := v.values[key]
 	return val, ok
 }
 
-func (v requestValues) Set(key, val any) {
-	v[key] = val
+func (v *requestValues) Set(key, val any) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	v.values[key] = val
 }
 
 type MetricsLabels interface {
@@ -73,7 +95,7 @@ func NewRequestOptions(c *config.Endpoint) *RequestOptions {
 		Backends: make([]string, 0, 1),
 		Metadata: make(map[string]string),
 		DoneFunc: func(ctx context.Context, di selector.DoneInfo) {},
-		Values:   make(requestValues, 5),
+		Values:   &requestValues{values: make(map[any]any, 5)},
 	}
 	o.Filters = []selector.NodeFilter{func(ctx context.Context, nodes []selector.Node) []selector.Node {
 		if len(o.Backends) == 0 {
@@ -97,6 +119,15 @@ func NewRequestOptions(c *config.Endpoint) *RequestOptions {
 	return o
 }
 
+// NewAttemptOptions returns the options of a concurrent attempt of the request,
+// the request scoped state is shared with it.
+func NewAttemptOptions(o *RequestOptions) *RequestOptions {
+	attempt := NewRequestOptions(o.Endpoint)
+	attempt.Values = o.Values
+	attempt.ClientIP = o.ClientIP
+	return attempt
+}
+
 // NewRequestContext returns a new Context that carries value.
 func NewRequestContext(ctx context.Context, o *RequestOptions) context.Context {
 	return context.WithValue(ctx, contextKey{}, o)
@@ -111,6 +142,15 @@ func FromRequestContext(ctx context.Context) (*RequestOptions, bool) {
 	return nil, false
 }
 
+// ClientIP returns the resolved client address of the request,
+// or its remote address outside of the proxy.
+func ClientIP(req *http.Request) string {
+	if o, ok := FromRequestContext(req.Context()); ok && o.ClientIP != "" {
+		return o.ClientIP
+	}
+	return clientip.RemoteIP(req)
+}
+
 // EndpointFromContext returns endpoint config from context.
 func EndpointFromContext(ctx context.Context) (*config.Endpoint, bool) {
 	o, ok := ctx.Value(contextKey{}).(*RequestOptions)
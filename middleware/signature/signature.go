@@ -40,28 +40,52 @@ func Middleware(c *config.Middleware) (middleware.Middleware, error) {
 
 	return func(next http.RoundTripper) http.RoundTripper {
 		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
-			var content []byte
-			if req.Method == http.MethodGet || req.Method == http.MethodDelete {
-				content = []byte(req.URL.Query().Encode())
-			} else {
-				dataBody, _ := io.ReadAll(req.Body)
-				req.Body = io.NopCloser(bytes.NewBuffer(dataBody))
-				content = dataBody
+			content, err := payload(req, 0)
+			if err != nil {
+				return nil, err
 			}
-
-			ts := time.Now().Unix()
-			timestamp := strconv.FormatInt(ts, 10)
-			// 添加时间戳
-			content = append(content, []byte(fmt.Sprintf("|%s", timestamp))...)
-			// 添加ak
-			content = append(content, []byte(fmt.Sprintf("|%s", sign.Ak))...)
-			// 加签
-			her := hmac.New(sha256.New, []byte(sign.Sk))
-			her.Write(content)
-
+			timestamp := strconv.FormatInt(time.Now().Unix(), 10)
 			req.Header.Set(timeHeader, timestamp)
-			req.Header.Set(tokenHeader, hex.EncodeToString(her.Sum(nil)))
+			req.Header.Set(tokenHeader, hex.EncodeToString(signContent(content, timestamp, sign.Ak, "", sign.Sk)))
 			return next.RoundTrip(req)
 		})
 	}, nil
 }
+
+// payload returns the signed content of the request: the query of GET and DELETE
+// requests, the body otherwise. The body is restored to be sent upstream.
+func payload(req *http.Request, maxSize int64) ([]byte, error) {
+	if req.Method == http.MethodGet || req.Method == http.MethodDelete {
+		return []byte(req.URL.Query().Encode()), nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return nil, nil
+	}
+	body := io.Reader(req.Body)
+	if maxSize > 0 {
+		body = io.LimitReader(req.Body, maxSize+1)
+	}
+	dataBody, err := io.ReadAll(body)
+	if err != nil {
+		return nil, err
+	}
+	if maxSize > 0 && int64(len(dataBody)) > maxSize {
+		return nil, errBodyTooLarge
+	}
+	req.Body = io.NopCloser(bytes.NewBuffer(dataBody))
+	return dataBody, nil
+}
+
+// signContent returns HMAC-SHA256(sk, content|timestamp|ak[|nonce]).
+func signContent(content []byte, timestamp, ak, nonce, sk string) []byte {
+	her := hmac.New(sha256.New, []byte(sk))
+	her.Write(content)
+	// 添加时间戳
+	her.Write([]byte(fmt.Sprintf("|%s", timestamp)))
+	// 添加ak
+	her.Write([]byte(fmt.Sprintf("|%s", ak)))
+	if nonce != "" {
+		her.Write([]byte(fmt.Sprintf("|%s", nonce)))
+	}
+	return her.Sum(nil)
+}
@@ -0,0 +1,321 @@
+package signature
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+const (
+	sigV4Algorithm       = "AWS4-HMAC-SHA256"
+	sigV4TimeFormat      = "20060102T150405Z"
+	sigV4DateFormat      = "20060102"
+	sigV4UnsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+func init() {
+	middleware.Register("sigv4", SigV4Middleware)
+}
+
+type SigV4 struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Region          string
+	Service         string
+	// UnsignedPayload skips the hash of the body, it is the default of s3
+	// unless SignedPayload is set.
+	UnsignedPayload bool
+	SignedPayload   bool
+	// MaxBodySize limits the streamed bodies read to be hashed, the bodies
+	// buffered for the retries are hashed without being read into memory.
+	MaxBodySize int64
+}
+
+// sigV4Signer signs requests with AWS Signature Version 4,
+// see https://docs.aws.amazon.com/IAM/latest/UserGuide/create-signed-request.html
+type sigV4Signer struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+	region          string
+	service         string
+	unsignedPayload bool
+	maxBodySize     int64
+	now             func() time.Time
+}
+
+func newSigV4Signer(options *SigV4) (*sigV4Signer, error) {
+	s := &sigV4Signer{
+		accessKeyID:     options.AccessKeyID,
+		secretAccessKey: options.SecretAccessKey,
+		sessionToken:    options.SessionToken,
+		region:          options.Region,
+		service:         options.Service,
+		unsignedPayload: options.UnsignedPayload || (options.Service == "s3" && !options.SignedPayload),
+		maxBodySize:     options.MaxBodySize,
+		now:             time.Now,
+	}
+	if s.maxBodySize <= 0 {
+		s.maxBodySize = defaultMaxBodySize
+	}
+	// credentials of the options win over the environment
+	if s.accessKeyID == "" && s.secretAccessKey == "" {
+		s.accessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.secretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s.sessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_REGION")
+	}
+	if s.region == "" {
+		s.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if s.accessKeyID == "" || s.secretAccessKey == "" {
+		return nil, errors.New("sigv4 requires access key id and secret access key")
+	}
+	if s.region == "" || s.service == "" {
+		return nil, errors.New("sigv4 requires region and service")
+	}
+	return s, nil
+}
+
+func hashSHA256(in []byte) string {
+	h := sha256.Sum256(in)
+	return hex.EncodeToString(h[:])
+}
+
+func hmacSHA256(key []byte, in string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(in))
+	return h.Sum(nil)
+}
+
+// uriEncode encodes everything but the unreserved characters,
+// slashes are kept when encoding a path.
+func uriEncode(in string, path bool) string {
+	var b strings.Builder
+	for i := 0; i < len(in); i++ {
+		c := in[i]
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (path && c == '/') {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteString("%")
+		b.WriteString(strings.ToUpper(hex.EncodeToString([]byte{c})))
+	}
+	return b.String()
+}
+
+// canonicalURI returns the encoded path, S3 paths are neither normalized nor encoded twice.
+func (s *sigV4Signer) canonicalURI(req *http.Request) string {
+	if s.service == "s3" {
+		p := req.URL.Path
+		if p == "" {
+			p = "/"
+		}
+		return uriEncode(p, true)
+	}
+	p := req.URL.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	cleaned := path.Clean(p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return uriEncode(cleaned, true)
+}
+
+func canonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(key, false)+"="+uriEncode(value, false))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func isSignedHeader(key string) bool {
+	return key == "content-type" || key == "content-md5" || strings.HasPrefix(key, "x-amz-")
+}
+
+// canonicalHeaders returns the canonical headers and the signed header names.
+func canonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	headers := map[string]string{"host": host}
+	for key, values := range req.Header {
+		key = strings.ToLower(key)
+		if !isSignedHeader(key) {
+			continue
+		}
+		trimmed := make([]string, 0, len(values))
+		for _, v := range values {
+			trimmed = append(trimmed, strings.Join(strings.Fields(v), " "))
+		}
+		headers[key] = strings.Join(trimmed, ",")
+	}
+	names := make([]string, 0, len(headers))
+	for key := range headers {
+		names = append(names, key)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, key := range names {
+		b.WriteString(key)
+		b.WriteString(":")
+		b.WriteString(headers[key])
+		b.WriteString("\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+// bufferPayload reads the streamed body to be hashed up to the max body size,
+// the bodies the proxy buffered for the retries are left in place.
+func (s *sigV4Signer) bufferPayload(req *http.Request) error {
+	if s.unsignedPayload || req.GetBody != nil || req.Body == nil || req.Body == http.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, s.maxBodySize+1))
+	if err != nil {
+		return err
+	}
+	if int64(len(body)) > s.maxBodySize {
+		return errBodyTooLarge
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(body)), nil
+	}
+	return nil
+}
+
+func (s *sigV4Signer) payloadHash(req *http.Request) (string, error) {
+	if s.unsignedPayload {
+		return sigV4UnsignedPayload, nil
+	}
+	if req.Body == nil || req.Body == http.NoBody {
+		return hashSHA256(nil), nil
+	}
+	if err := s.bufferPayload(req); err != nil {
+		return "", err
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, body); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// sign adds the authorization headers to the request, it must be called
+// once the request is final since the path, query and host are signed.
+func (s *sigV4Signer) sign(req *http.Request) error {
+	payloadHash, err := s.payloadHash(req)
+	if err != nil {
+		return err
+	}
+	t := s.now().UTC()
+	amzDate := t.Format(sigV4TimeFormat)
+	date := t.Format(sigV4DateFormat)
+	req.Header.Del("Authorization")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if s.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.sessionToken)
+	}
+	if s.service == "s3" || s.unsignedPayload {
+		req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	headers, signedHeaders := canonicalHeaders(req)
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		s.canonicalURI(req),
+		canonicalQuery(req),
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date, s.region, s.service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		sigV4Algorithm,
+		amzDate,
+		scope,
+		hashSHA256([]byte(canonicalRequest)),
+	}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.secretAccessKey), date)
+	key = hmacSHA256(key, s.region)
+	key = hmacSHA256(key, s.service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", sigV4Algorithm+
+		" Credential="+s.accessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+
+		", Signature="+signature)
+	return nil
+}
+
+// SigV4Middleware signs upstream requests with AWS Signature Version 4.
+// The request is signed by the client right before it is sent, after
+// every middleware, e.g. rewrite, has changed its path and host.
+func SigV4Middleware(c *config.Middleware) (middleware.Middleware, error) {
+	options := &SigV4{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	signer, err := newSigV4Signer(options)
+	if err != nil {
+		return nil, err
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			// the body is read before the client, a body too large is rejected
+			// rather than failing the upstream request
+			if err := signer.bufferPayload(req); err != nil {
+				if errors.Is(err, errBodyTooLarge) {
+					return render.NewResponse(req, render.NewError(http.StatusRequestEntityTooLarge, err.Error())), nil
+				}
+				return nil, err
+			}
+			if reqOpt, ok := middleware.FromRequestContext(req.Context()); ok {
+				reqOpt.Signer = signer.sign
+				return next.RoundTrip(req)
+			}
+			if err := signer.sign(req); err != nil {
+				return nil, err
+			}
+			return next.RoundTrip(req)
+		})
+	}, nil
+}
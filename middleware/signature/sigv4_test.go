@@ -0,0 +1,183 @@
+package signature
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The vectors come from the AWS Signature Version 4 test suite
+// and the signing examples of the AWS documentation.
+func TestSigV4Golden(t *testing.T) {
+	at := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	tests := []struct {
+		name          string
+		service       string
+		method        string
+		url           string
+		body          string
+		headers       map[string]string
+		authorization string
+	}{
+		{
+			name:          "get-vanilla",
+			service:       "service",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31",
+		},
+		{
+			name:          "get-vanilla-query-order-key-case",
+			service:       "service",
+			method:        http.MethodGet,
+			url:           "https://example.amazonaws.com/?Param2=value2&Param1=value1",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=b97d918cfa904a5beff61c982a1b6f458b799221646efd99d3219ec94cdf2500",
+		},
+		{
+			name:          "post-vanilla",
+			service:       "service",
+			method:        http.MethodPost,
+			url:           "https://example.amazonaws.com/",
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5da7c1a2acd57cee7505fc6676e4e544621c30862966e37dddb68e92efbe5d6b",
+		},
+		{
+			name:          "iam-list-users",
+			service:       "iam",
+			method:        http.MethodGet,
+			url:           "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08",
+			headers:       map[string]string{"Content-Type": "application/x-www-form-urlencoded; charset=utf-8"},
+			authorization: "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := newSigV4Signer(&SigV4{
+				AccessKeyID:     "AKIDEXAMPLE",
+				SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY",
+				Region:          "us-east-1",
+				Service:         tt.service,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			signer.now = func() time.Time { return at }
+			req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			if err := signer.sign(req); err != nil {
+				t.Fatal(err)
+			}
+			if got := req.Header.Get("Authorization"); got != tt.authorization {
+				t.Fatalf("authorization = %s\nwant %s", got, tt.authorization)
+			}
+		})
+	}
+}
+
+func TestSigV4Env(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDENV")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	t.Setenv("AWS_REGION", "eu-west-1")
+	signer, err := newSigV4Signer(&SigV4{Service: "s3", UnsignedPayload: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodPut, "http://bucket.s3.local/key%20name", strings.NewReader("data"))
+	if err := signer.sign(req); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(req.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDENV/") ||
+		!strings.Contains(req.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request, SignedHeaders=host;x-amz-content-sha256;x-amz-date;x-amz-security-token,") {
+		t.Fatalf("unexpected authorization: %s", req.Header.Get("Authorization"))
+	}
+	if req.Header.Get("X-Amz-Content-Sha256") != sigV4UnsignedPayload || req.Header.Get("X-Amz-Security-Token") != "token" {
+		t.Fatalf("unexpected headers: %v", req.Header)
+	}
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// guardedBody fails the reads of the body which must not be read.
+type guardedBody struct {
+	t *testing.T
+}
+
+func (b guardedBody) Read([]byte) (int, error) {
+	b.t.Error("unexpected read of the body")
+	return 0, io.EOF
+}
+
+func (b guardedBody) Close() error { return nil }
+
+func TestSigV4Payload(t *testing.T) {
+	newSigner := func(options *SigV4) *sigV4Signer {
+		options.AccessKeyID, options.SecretAccessKey, options.Region = "AKIDEXAMPLE", "secret", "us-east-1"
+		signer, err := newSigV4Signer(options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signer
+	}
+
+	// the bodies buffered for the retries are hashed from their replay, far
+	// above the memory limit of the buffers and without reading the body
+	size := int64(64 << 20)
+	req := httptest.NewRequest(http.MethodPut, "http://bucket.s3.local/key", nil)
+	req.Body = guardedBody{t: t}
+	req.ContentLength = size
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(io.LimitReader(zeroReader{}, size)), nil
+	}
+	h := sha256.New()
+	io.Copy(h, io.LimitReader(zeroReader{}, size))
+	if err := newSigner(&SigV4{Service: "s3", SignedPayload: true}).sign(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != hex.EncodeToString(h.Sum(nil)) {
+		t.Fatalf("unexpected payload hash: %s", got)
+	}
+
+	// s3 payloads are unsigned by default
+	req = httptest.NewRequest(http.MethodPut, "http://bucket.s3.local/key", nil)
+	req.Body = guardedBody{t: t}
+	if err := newSigner(&SigV4{Service: "s3"}).sign(req); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.Header.Get("X-Amz-Content-Sha256"); got != sigV4UnsignedPayload {
+		t.Fatalf("expected an unsigned payload, got %s", got)
+	}
+
+	// the streamed bodies are read up to the max body size
+	read := &countingBody{Reader: io.LimitReader(zeroReader{}, size)}
+	req = httptest.NewRequest(http.MethodPost, "https://example.amazonaws.com/", read)
+	if err := newSigner(&SigV4{Service: "service", MaxBodySize: 1 << 10}).sign(req); !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("expected the body to be too large, got %v", err)
+	}
+	if read.n > 1<<10+1 {
+		t.Fatalf("expected the body to be read up to the max body size, read %d bytes", read.n)
+	}
+}
+
+type countingBody struct {
+	io.Reader
+	n int64
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	b.n += int64(n)
+	return n, err
+}
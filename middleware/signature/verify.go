@@ -0,0 +1,207 @@
+package signature
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+const (
+	akHeader    = "x-md-sign-ak"
+	nonceHeader = "x-md-sign-nonce"
+
+	defaultMaxSkew        = 5 * time.Minute
+	defaultNonceCacheSize = 100000
+	defaultMaxBodySize    = 10 << 20
+)
+
+var (
+	errBodyTooLarge     = errors.New("request body too large")
+	errMissingSignature = errors.New("missing signature")
+	errUnknownAk        = errors.New("unknown access key")
+	errExpiredSignature = errors.New("signature expired")
+	errInvalidSignature = errors.New("invalid signature")
+	errReplayedRequest  = errors.New("replayed request")
+	errNonceCacheFull   = errors.New("too many signed requests")
+)
+
+func init() {
+	middleware.Register("signature_verify", VerifyMiddleware)
+}
+
+type verifiedKey struct{}
+
+type Key struct {
+	Ak string
+	Sk string
+}
+
+type Verify struct {
+	Keys           []Key
+	MaxSkew        time.Duration
+	NonceCacheSize int
+	MaxBodySize    int64
+}
+
+// nonceCache remembers the signatures seen within the accepted time window.
+// Entries share the same ttl, so the insertion order is the expiration order.
+type nonceCache struct {
+	lock  sync.Mutex
+	ttl   time.Duration
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+type nonceEntry struct {
+	key      string
+	expireAt time.Time
+}
+
+func newNonceCache(ttl time.Duration, size int) *nonceCache {
+	return &nonceCache{
+		ttl:   ttl,
+		size:  size,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Add records the key, it fails if the key has already been seen or if the
+// cache is full of unexpired keys: evicting them would let their replays through.
+func (c *nonceCache) Add(key string, now time.Time) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for elem := c.order.Front(); elem != nil; elem = c.order.Front() {
+		entry := elem.Value.(*nonceEntry)
+		if now.Before(entry.expireAt) {
+			break
+		}
+		c.order.Remove(elem)
+		delete(c.items, entry.key)
+	}
+	if _, ok := c.items[key]; ok {
+		return errReplayedRequest
+	}
+	if c.order.Len() >= c.size {
+		return errNonceCacheFull
+	}
+	c.items[key] = c.order.PushBack(&nonceEntry{key: key, expireAt: now.Add(c.ttl)})
+	return nil
+}
+
+type verifier struct {
+	keys        map[string]string
+	maxSkew     time.Duration
+	maxBodySize int64
+	nonces      *nonceCache
+}
+
+func (v *verifier) verify(req *http.Request) error {
+	ak := req.Header.Get(akHeader)
+	timestamp := req.Header.Get(timeHeader)
+	token := req.Header.Get(tokenHeader)
+	if ak == "" || timestamp == "" || token == "" {
+		return errMissingSignature
+	}
+	sk, ok := v.keys[ak]
+	if !ok {
+		return errUnknownAk
+	}
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errInvalidSignature
+	}
+	now := time.Now()
+	if skew := now.Sub(time.Unix(ts, 0)); skew > v.maxSkew || skew < -v.maxSkew {
+		return errExpiredSignature
+	}
+	signature, err := hex.DecodeString(token)
+	if err != nil {
+		return errInvalidSignature
+	}
+	content, err := payload(req, v.maxBodySize)
+	if err != nil {
+		return err
+	}
+	// the nonce is signed too, so that identical requests sent within the
+	// same second are not taken for replays
+	nonce := req.Header.Get(nonceHeader)
+	if !hmac.Equal(signature, signContent(content, timestamp, ak, nonce, sk)) {
+		return errInvalidSignature
+	}
+	return v.nonces.Add(ak+"|"+token, now)
+}
+
+// VerifyMiddleware verifies the signature of inbound requests, signed the same way
+// the signature middleware signs outbound requests, and rejects replays.
+func VerifyMiddleware(c *config.Middleware) (middleware.Middleware, error) {
+	options := &Verify{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	if len(options.Keys) == 0 {
+		return nil, errors.New("signature verify requires at least one key")
+	}
+	if options.MaxSkew <= 0 {
+		options.MaxSkew = defaultMaxSkew
+	}
+	if options.NonceCacheSize <= 0 {
+		options.NonceCacheSize = defaultNonceCacheSize
+	}
+	if options.MaxBodySize <= 0 {
+		options.MaxBodySize = defaultMaxBodySize
+	}
+	v := &verifier{
+		keys:        make(map[string]string, len(options.Keys)),
+		maxSkew:     options.MaxSkew,
+		maxBodySize: options.MaxBodySize,
+		// a signature is accepted until its timestamp is older than the max skew,
+		// and the timestamp may be up to max skew ahead of now.
+		nonces: newNonceCache(2*options.MaxSkew, options.NonceCacheSize),
+	}
+	for _, key := range options.Keys {
+		if key.Ak == "" || key.Sk == "" {
+			return nil, errors.New("signature verify key requires ak and sk")
+		}
+		v.keys[key.Ak] = key.Sk
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			reqOpt, ok := middleware.FromRequestContext(req.Context())
+			if ok {
+				// retries of a verified request would be taken for replays
+				if _, verified := reqOpt.Values.Get(verifiedKey{}); verified {
+					return next.RoundTrip(req)
+				}
+			}
+			if err := v.verify(req); err != nil {
+				statusCode := http.StatusUnauthorized
+				switch {
+				case errors.Is(err, errBodyTooLarge):
+					statusCode = http.StatusRequestEntityTooLarge
+				case errors.Is(err, errNonceCacheFull):
+					statusCode = http.StatusServiceUnavailable
+				}
+				return render.NewResponse(req, render.NewError(statusCode, err.Error())), nil
+			}
+			if ok {
+				reqOpt.Values.Set(verifiedKey{}, true)
+			}
+			return next.RoundTrip(req)
+		})
+	}, nil
+}
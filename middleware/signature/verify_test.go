@@ -0,0 +1,126 @@
+package signature
+
+import (
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+func signRequest(req *http.Request, ak, sk, nonce string, at time.Time) {
+	content, _ := payload(req, 0)
+	timestamp := strconv.FormatInt(at.Unix(), 10)
+	req.Header.Set(akHeader, ak)
+	req.Header.Set(timeHeader, timestamp)
+	if nonce != "" {
+		req.Header.Set(nonceHeader, nonce)
+	}
+	req.Header.Set(tokenHeader, hex.EncodeToString(signContent(content, timestamp, ak, nonce, sk)))
+}
+
+func TestVerifyMiddleware(t *testing.T) {
+	m, err := VerifyMiddleware(&config.Middleware{Options: map[string]any{
+		"keys": []map[string]any{
+			{"ak": "old", "sk": "old-secret"},
+			{"ak": "new", "sk": "new-secret"},
+		},
+		"maxSkew": "1m",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tripper := m(middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		body, _ := io.ReadAll(req.Body)
+		w := httptest.NewRecorder()
+		_, _ = w.Write(body)
+		return w.Result(), nil
+	}))
+	do := func(req *http.Request) *http.Response {
+		resp, err := tripper.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	newRequest := func() *http.Request {
+		return httptest.NewRequest(http.MethodPost, "/open/orders", strings.NewReader(`{"id":1}`))
+	}
+	now := time.Now()
+
+	for _, key := range [][2]string{{"old", "old-secret"}, {"new", "new-secret"}} {
+		req := newRequest()
+		signRequest(req, key[0], key[1], "", now)
+		resp := do(req)
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != `{"id":1}` {
+			t.Fatalf("%s: status = %d, body = %s", key[0], resp.StatusCode, body)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(req *http.Request)
+	}{
+		{"missing", func(req *http.Request) {}},
+		{"unknown ak", func(req *http.Request) { signRequest(req, "other", "old-secret", "", now) }},
+		{"wrong secret", func(req *http.Request) { signRequest(req, "old", "new-secret", "", now) }},
+		{"expired", func(req *http.Request) { signRequest(req, "old", "old-secret", "", now.Add(-2*time.Minute)) }},
+		{"future", func(req *http.Request) { signRequest(req, "old", "old-secret", "", now.Add(2*time.Minute)) }},
+		{"tampered", func(req *http.Request) {
+			signRequest(req, "old", "old-secret", "", now)
+			req.Body = io.NopCloser(strings.NewReader(`{"id":2}`))
+		}},
+		{"replayed", func(req *http.Request) { signRequest(req, "old", "old-secret", "", now) }},
+	}
+	for _, tt := range tests {
+		req := newRequest()
+		tt.prepare(req)
+		if resp := do(req); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: status = %d", tt.name, resp.StatusCode)
+		}
+	}
+
+	// identical requests with distinct nonces are not replays
+	for _, nonce := range []string{"n1", "n2"} {
+		req := newRequest()
+		signRequest(req, "new", "new-secret", nonce, now)
+		if resp := do(req); resp.StatusCode != http.StatusOK {
+			t.Fatalf("nonce %s: status = %d", nonce, resp.StatusCode)
+		}
+	}
+}
+
+func TestNonceCache(t *testing.T) {
+	c := newNonceCache(time.Minute, 2)
+	now := time.Now()
+	if c.Add("a", now) != nil || c.Add("a", now) != errReplayedRequest {
+		t.Fatal("duplicate key accepted")
+	}
+	if c.Add("a", now.Add(2*time.Minute)) != nil {
+		t.Fatal("expired key rejected")
+	}
+	later := now.Add(2 * time.Minute)
+	if c.Add("b", later) != nil {
+		t.Fatal("key rejected below the cache size")
+	}
+	// the unexpired keys are never evicted, new keys are rejected instead
+	if err := c.Add("c", later); err != errNonceCacheFull {
+		t.Fatalf("expected a full cache, got %v", err)
+	}
+	if c.Add("a", later) != errReplayedRequest || c.Add("b", later) != errReplayedRequest {
+		t.Fatal("replay accepted while the cache is full")
+	}
+	if len(c.items) != 2 || c.order.Len() != 2 {
+		t.Fatalf("cache size = %d", len(c.items))
+	}
+	if c.Add("c", later.Add(time.Minute)) != nil || len(c.items) != 1 {
+		t.Fatalf("expected the expired keys to make room, cache size = %d", len(c.items))
+	}
+}
@@ -0,0 +1,204 @@
+package sticky
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/utils"
+)
+
+const _defaultCookie = "GATEWAY_STICKY"
+
+func init() {
+	middleware.Register("sticky", Middleware)
+}
+
+type Sticky struct {
+	// Cookie is the name of the affinity cookie.
+	Cookie string
+	// Secret is the key signing the cookie, it must be shared by the gateway instances.
+	Secret string
+	// MaxAge is the lifetime of the cookie, it lasts for the browser session if zero.
+	MaxAge time.Duration
+	Path   string
+	Domain string
+	Secure bool
+	// SameSite is lax, strict or none.
+	SameSite string
+}
+
+// pinnedKey holds the node address of the request cookie.
+type pinnedKey struct{}
+
+// filterKey marks the request options already filtering the pinned node,
+// the options are shared by the retries but not by the hedged attempts.
+type filterKey struct {
+	opts *middleware.RequestOptions
+}
+
+type sticky struct {
+	name     string
+	secret   []byte
+	maxAge   time.Duration
+	path     string
+	domain   string
+	secure   bool
+	sameSite http.SameSite
+}
+
+func parseSameSite(in string) (http.SameSite, error) {
+	switch strings.ToLower(in) {
+	case "":
+		return http.SameSiteDefaultMode, nil
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("invalid sticky cookie same site: %s", in)
+}
+
+func (s *sticky) sign(address string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(address))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// encode returns the cookie value of the node: its address and the signature of it.
+func (s *sticky) encode(address string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(address)) + "." + s.sign(address)
+}
+
+// decode returns the node address of the cookie value, if its signature is valid.
+func (s *sticky) decode(value string) (string, bool) {
+	encoded, signature, ok := strings.Cut(value, ".")
+	if !ok {
+		return "", false
+	}
+	address, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil || len(address) == 0 {
+		return "", false
+	}
+	if !hmac.Equal([]byte(signature), []byte(s.sign(string(address)))) {
+		return "", false
+	}
+	return string(address), true
+}
+
+// pinned returns the node address of the request cookie.
+func (s *sticky) pinned(req *http.Request) string {
+	cookie, err := req.Cookie(s.name)
+	if err != nil {
+		return ""
+	}
+	address, ok := s.decode(cookie.Value)
+	if !ok {
+		return ""
+	}
+	return address
+}
+
+func (s *sticky) cookie(address string) *http.Cookie {
+	c := &http.Cookie{
+		Name:     s.name,
+		Value:    s.encode(address),
+		Path:     s.path,
+		Domain:   s.domain,
+		Secure:   s.secure,
+		HttpOnly: true,
+		SameSite: s.sameSite,
+	}
+	if s.maxAge > 0 {
+		c.MaxAge = int(s.maxAge / time.Second)
+	}
+	return c
+}
+
+// filter keeps the pinned node, all nodes are kept when it has gone away
+// or has already been tried by the request.
+func filter(address string) selector.NodeFilter {
+	return func(_ context.Context, nodes []selector.Node) []selector.Node {
+		for _, n := range nodes {
+			if n.Address() == address {
+				return []selector.Node{n}
+			}
+		}
+		return nodes
+	}
+}
+
+// Middleware pins the requests of a client to the node that served its first
+// request, through a signed cookie set by the gateway.
+func Middleware(c *config.Middleware) (middleware.Middleware, error) {
+	options := &Sticky{}
+	if c.Options != nil {
+		if err := utils.Copy(c.Options, options); err != nil {
+			return nil, err
+		}
+	}
+	if options.Secret == "" {
+		return nil, errors.New("sticky requires a secret to sign the cookie")
+	}
+	sameSite, err := parseSameSite(options.SameSite)
+	if err != nil {
+		return nil, err
+	}
+	s := &sticky{
+		name:     options.Cookie,
+		secret:   []byte(options.Secret),
+		maxAge:   options.MaxAge,
+		path:     options.Path,
+		domain:   options.Domain,
+		secure:   options.Secure,
+		sameSite: sameSite,
+	}
+	if s.name == "" {
+		s.name = _defaultCookie
+	}
+	if s.path == "" {
+		s.path = "/"
+	}
+	return func(next http.RoundTripper) http.RoundTripper {
+		return middleware.RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+			ctx := req.Context()
+			reqOpt, ok := middleware.FromRequestContext(ctx)
+			if !ok {
+				return next.RoundTrip(req)
+			}
+			var address string
+			if v, ok := reqOpt.Values.Get(pinnedKey{}); ok {
+				address = v.(string)
+			} else {
+				address = s.pinned(req)
+				reqOpt.Values.Set(pinnedKey{}, address)
+			}
+			if address != "" {
+				if _, ok := reqOpt.Values.Get(filterKey{reqOpt}); !ok {
+					reqOpt.Values.Set(filterKey{reqOpt}, struct{}{})
+					middleware.WithSelectorFitler(ctx, filter(address))
+				}
+			}
+			resp, err := next.RoundTrip(req)
+			if err != nil || reqOpt.CurrentNode == nil {
+				return resp, err
+			}
+			if selected := reqOpt.CurrentNode.Address(); selected != address {
+				resp.Header.Add("Set-Cookie", s.cookie(selected).String())
+			}
+			return resp, nil
+		})
+	}, nil
+}
@@ -0,0 +1,108 @@
+package sticky
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+type testNode struct {
+	selector.Node
+	address string
+}
+
+func (n *testNode) Address() string { return n.address }
+
+// pool selects the first node left by the filters of the request.
+type pool struct {
+	nodes []selector.Node
+}
+
+func (p *pool) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqOpt, _ := middleware.FromRequestContext(req.Context())
+	nodes := append([]selector.Node(nil), p.nodes...)
+	for _, f := range reqOpt.Filters {
+		nodes = f(req.Context(), nodes)
+	}
+	reqOpt.CurrentNode = nodes[0]
+	return httptest.NewRecorder().Result(), nil
+}
+
+func newPool(addresses ...string) *pool {
+	p := &pool{}
+	for _, address := range addresses {
+		p.nodes = append(p.nodes, &testNode{address: address})
+	}
+	return p
+}
+
+func send(t *testing.T, rt http.RoundTripper, cookie *http.Cookie) (string, *http.Cookie) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	reqOpt := middleware.NewRequestOptions(&config.Endpoint{Path: "/"})
+	req = req.WithContext(middleware.NewRequestContext(context.Background(), reqOpt))
+	resp, err := rt.RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var set *http.Cookie
+	if cookies := resp.Cookies(); len(cookies) > 0 {
+		set = cookies[0]
+	}
+	return reqOpt.CurrentNode.Address(), set
+}
+
+func TestMiddleware(t *testing.T) {
+	if _, err := Middleware(&config.Middleware{Name: "sticky"}); err == nil {
+		t.Fatal("expected a missing secret to fail")
+	}
+	m, err := Middleware(&config.Middleware{Name: "sticky", Options: map[string]any{
+		"secret": "s3cr3t",
+		"maxAge": "1h",
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newPool("10.0.0.1:80", "10.0.0.2:80")
+	rt := m(p)
+
+	node, cookie := send(t, rt, nil)
+	if node != "10.0.0.1:80" || cookie == nil {
+		t.Fatalf("expected the cookie of the first node, got %s %v", node, cookie)
+	}
+	if cookie.Name != _defaultCookie || cookie.MaxAge != 3600 || !cookie.HttpOnly {
+		t.Fatalf("unexpected cookie: %v", cookie)
+	}
+
+	// the cookie wins over the node picked by the balancer
+	p.nodes[0], p.nodes[1] = p.nodes[1], p.nodes[0]
+	node, set := send(t, rt, cookie)
+	if node != "10.0.0.1:80" || set != nil {
+		t.Fatalf("expected the pinned node without new cookie, got %s %v", node, set)
+	}
+
+	// a forged cookie is ignored
+	forged := &http.Cookie{Name: cookie.Name, Value: "MTAuMC4wLjE6ODA.forged"}
+	if node, set = send(t, rt, forged); node != "10.0.0.2:80" || set == nil {
+		t.Fatalf("expected the forged cookie to be replaced, got %s %v", node, set)
+	}
+
+	// the pinned node has gone away
+	p.nodes = p.nodes[:1]
+	node, set = send(t, rt, cookie)
+	if node != "10.0.0.2:80" || set == nil {
+		t.Fatalf("expected a fallback to another node, got %s %v", node, set)
+	}
+	if address, ok := (&sticky{secret: []byte("s3cr3t")}).decode(set.Value); !ok || address != "10.0.0.2:80" {
+		t.Fatalf("expected the cookie of the new node, got %s", address)
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync/atomic"
+)
+
+var (
+	// _bodyMemoryLimit is the size of a buffered request body kept in memory,
+	// the rest of the body spills into a temporary file.
+	_bodyMemoryLimit = int64(1 << 20)
+
+	errBodyTooLarge = errors.New("request body too large")
+)
+
+func init() {
+	if v := os.Getenv("PROXY_BODY_MEMORY_LIMIT"); v != "" {
+		limit, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			panic(err)
+		}
+		_bodyMemoryLimit = limit
+	}
+}
+
+// bodyBuffer holds a request body so that it can be replayed on retry.
+type bodyBuffer struct {
+	mem  bytes.Buffer
+	file *os.File
+	size int64
+}
+
+func (b *bodyBuffer) Write(p []byte) (int, error) {
+	if b.file == nil && int64(b.mem.Len()+len(p)) > _bodyMemoryLimit {
+		file, err := os.CreateTemp("", "gateway-body-*")
+		if err != nil {
+			return 0, err
+		}
+		b.file = file
+		if _, err := b.file.Write(b.mem.Bytes()); err != nil {
+			return 0, err
+		}
+		b.mem = bytes.Buffer{}
+	}
+	var (
+		n   int
+		err error
+	)
+	if b.file != nil {
+		n, err = b.file.Write(p)
+	} else {
+		n, err = b.mem.Write(p)
+	}
+	b.size += int64(n)
+	return n, err
+}
+
+// Size returns the number of buffered bytes.
+func (b *bodyBuffer) Size() int64 {
+	return b.size
+}
+
+// Reader returns a new reader positioned at the start of the body.
+func (b *bodyBuffer) Reader() io.ReadCloser {
+	if b.file != nil {
+		return io.NopCloser(io.NewSectionReader(b.file, 0, b.size))
+	}
+	return io.NopCloser(bytes.NewReader(b.mem.Bytes()))
+}
+
+// Close releases the temporary file if the body has been spilled.
+func (b *bodyBuffer) Close() error {
+	if b.file == nil {
+		return nil
+	}
+	name := b.file.Name()
+	err := b.file.Close()
+	_ = os.Remove(name)
+	return err
+}
+
+// bufferBody reads the whole body into a replayable buffer,
+// bodies larger than maxSize are rejected with errBodyTooLarge.
+func bufferBody(body io.Reader, maxSize int64) (*bodyBuffer, error) {
+	buf := &bodyBuffer{}
+	if body == nil || body == http.NoBody {
+		return buf, nil
+	}
+	src := body
+	if maxSize > 0 {
+		src = io.LimitReader(body, maxSize+1)
+	}
+	if _, err := io.Copy(buf, src); err != nil {
+		buf.Close()
+		return nil, err
+	}
+	if maxSize > 0 && buf.Size() > maxSize {
+		buf.Close()
+		return nil, errBodyTooLarge
+	}
+	return buf, nil
+}
+
+// countingReader counts the bytes read from a streamed request body,
+// reading past limit fails with errBodyTooLarge.
+type countingReader struct {
+	io.ReadCloser
+	limit int64
+	n     int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	total := atomic.AddInt64(&r.n, int64(n))
+	if r.limit > 0 && total > r.limit {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+func (r *countingReader) Count() int64 {
+	return atomic.LoadInt64(&r.n)
+}
+
+// exceedsMaxBodySize reports whether the declared content length is over the limit.
+func exceedsMaxBodySize(req *http.Request, maxSize int64) bool {
+	return maxSize > 0 && req.ContentLength > maxSize
+}
@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+func TestBufferBody(t *testing.T) {
+	limit := _bodyMemoryLimit
+	_bodyMemoryLimit = 8
+	defer func() { _bodyMemoryLimit = limit }()
+
+	tests := []struct {
+		body    string
+		maxSize int64
+		spilled bool
+		err     error
+	}{
+		{body: "small", maxSize: 0},
+		{body: "larger than memory", maxSize: 0, spilled: true},
+		{body: "larger than memory", maxSize: 18, spilled: true},
+		{body: "larger than memory", maxSize: 10, err: errBodyTooLarge},
+	}
+	for _, item := range tests {
+		buf, err := bufferBody(bytes.NewBufferString(item.body), item.maxSize)
+		if !errors.Is(err, item.err) {
+			t.Fatalf("body %q: expected error %v, got %v", item.body, item.err, err)
+		}
+		if err != nil {
+			continue
+		}
+		if (buf.file != nil) != item.spilled {
+			t.Errorf("body %q: expected spilled %v", item.body, item.spilled)
+		}
+		// every reader replays the body from the start
+		for i := 0; i < 2; i++ {
+			b, _ := io.ReadAll(buf.Reader())
+			if string(b) != item.body {
+				t.Errorf("body %q: replay %d got %q", item.body, i, b)
+			}
+		}
+		if err := buf.Close(); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestStreamBody(t *testing.T) {
+	var hits atomic.Int64
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// a streamed body keeps its unknown length up to the upstream
+		fmt.Fprintf(w, "%d:%s", r.ContentLength, b)
+	}))
+	defer upstream.Close()
+	srv := newTestProxy(t, config.Endpoint{Path: "/stream", Protocol: "HTTP", MaxBodySize: 16, Backends: backendOf(upstream)})
+
+	post := func(body io.Reader, contentLength int64) (int, string) {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/stream", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.ContentLength = contentLength
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(b)
+	}
+
+	if code, body := post(io.MultiReader(strings.NewReader("chunked")), -1); code != http.StatusOK || body != "-1:chunked" {
+		t.Fatalf("expected the body to be streamed, got %d %q", code, body)
+	}
+	if code, body := post(strings.NewReader("sized"), 5); code != http.StatusOK || body != "5:sized" {
+		t.Fatalf("expected the body to be forwarded, got %d %q", code, body)
+	}
+
+	hits.Store(0)
+	if code, _ := post(strings.NewReader("declared body over the limit"), 28); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 on a declared length over the limit, got %d", code)
+	}
+	if hits.Load() != 0 {
+		t.Fatal("expected the oversized body not to reach the upstream")
+	}
+	if code, _ := post(io.MultiReader(strings.NewReader(strings.Repeat("streamed ", 1024))), -1); code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 on a streamed body over the limit, got %d", code)
+	}
+}
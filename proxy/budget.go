@@ -0,0 +1,228 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+const (
+	_defaultRetryBudgetWindow = 10 * time.Second
+	_retryBudgetBuckets       = 10
+)
+
+var (
+	errRetryBudgetExhausted = errors.New("retry budget exhausted")
+
+	_metricRetryBudgetRemaining = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "retry_budget_remaining",
+		Help:      "The number of retries still allowed by the retry budget",
+	}, []string{"service"})
+
+	globalRetryBudgets = &retryBudgets{budgets: map[string]*retryBudget{}}
+)
+
+func init() {
+	prometheus.MustRegister(_metricRetryBudgetRemaining)
+}
+
+type retryBudgets struct {
+	lock    sync.Mutex
+	budgets map[string]*retryBudget
+}
+
+// get returns the budget shared by the endpoints of the same service,
+// the latest loaded configuration takes effect.
+func (r *retryBudgets) get(key string, c *config.RetryBudget) *retryBudget {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	budget, ok := r.budgets[key]
+	if !ok {
+		budget = newRetryBudget(key)
+		r.budgets[key] = budget
+	}
+	budget.configure(c)
+	return budget
+}
+
+// prune drops the budgets no endpoint of the loaded configuration uses,
+// along with their gauge.
+func (r *retryBudgets) prune(endpoints []config.Endpoint) {
+	keys := make(map[string]struct{}, len(endpoints))
+	for i := range endpoints {
+		if e := &endpoints[i]; e.Retry != nil && e.Retry.Budget != nil {
+			keys[retryBudgetKey(e)] = struct{}{}
+		}
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for key := range r.budgets {
+		if _, ok := keys[key]; !ok {
+			delete(r.budgets, key)
+			_metricRetryBudgetRemaining.DeleteLabelValues(key)
+		}
+	}
+}
+
+func retryBudgetKey(e *config.Endpoint) string {
+	if service := e.Metadata["service"]; service != "" {
+		return service
+	}
+	return e.Method + " " + e.Path
+}
+
+// validateRetryBudgets rejects the endpoints of a service setting different
+// budgets, since they share the same one.
+func validateRetryBudgets(endpoints []config.Endpoint) error {
+	budgets := make(map[string]*config.Endpoint, len(endpoints))
+	for i := range endpoints {
+		e := &endpoints[i]
+		if e.Retry == nil || e.Retry.Budget == nil {
+			continue
+		}
+		key := retryBudgetKey(e)
+		other, ok := budgets[key]
+		if !ok {
+			budgets[key] = e
+			continue
+		}
+		if *other.Retry.Budget != *e.Retry.Budget {
+			return fmt.Errorf("conflicting retry budgets of %s: [%s] %s and [%s] %s", key, other.Method, other.Path, e.Method, e.Path)
+		}
+	}
+	return nil
+}
+
+func prepareRetryBudget(e *config.Endpoint) *retryBudget {
+	if e.Retry == nil || e.Retry.Budget == nil {
+		return nil
+	}
+	return globalRetryBudgets.get(retryBudgetKey(e), e.Retry.Budget)
+}
+
+type budgetBucket struct {
+	epoch    int64
+	requests int64
+	retries  int64
+}
+
+// retryBudget limits retries to a ratio of the requests over a sliding window,
+// plus a minimum number of retries per second. The minimum refills continuously
+// and never accumulates past the share of one bucket, not to be spent in a burst.
+type retryBudget struct {
+	lock      sync.Mutex
+	ratio     float64
+	minPerSec int
+	window    time.Duration
+	bucketDur time.Duration
+	buckets   [_retryBudgetBuckets]budgetBucket
+	gauge     prometheus.Gauge
+	now       func() time.Time
+
+	minTokens float64
+	minRefill time.Time
+}
+
+func newRetryBudget(key string) *retryBudget {
+	return &retryBudget{
+		gauge: _metricRetryBudgetRemaining.WithLabelValues(key),
+		now:   time.Now,
+	}
+}
+
+func (b *retryBudget) configure(c *config.RetryBudget) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	window := c.Window
+	if window <= 0 {
+		window = _defaultRetryBudgetWindow
+	}
+	if window != b.window {
+		b.buckets = [_retryBudgetBuckets]budgetBucket{}
+	}
+	b.ratio = c.Ratio
+	b.minPerSec = c.MinRetriesPerSecond
+	b.window = window
+	b.bucketDur = window / _retryBudgetBuckets
+	b.minTokens = min(b.minTokens, b.minBurst())
+}
+
+// minBurst is the number of retries of the minimum allowed at once.
+func (b *retryBudget) minBurst() float64 {
+	return max(1, float64(b.minPerSec)*b.bucketDur.Seconds())
+}
+
+// minimum refills the retries of the minimum, it must be called with the lock held.
+func (b *retryBudget) minimum(now time.Time) float64 {
+	if b.minPerSec <= 0 {
+		return 0
+	}
+	if b.minRefill.IsZero() {
+		b.minTokens = b.minBurst()
+	} else if elapsed := now.Sub(b.minRefill); elapsed > 0 {
+		b.minTokens = min(b.minBurst(), b.minTokens+elapsed.Seconds()*float64(b.minPerSec))
+	}
+	b.minRefill = now
+	return b.minTokens
+}
+
+func (b *retryBudget) current(now time.Time) *budgetBucket {
+	epoch := now.UnixNano() / int64(b.bucketDur)
+	bucket := &b.buckets[epoch%_retryBudgetBuckets]
+	if bucket.epoch != epoch {
+		*bucket = budgetBucket{epoch: epoch}
+	}
+	return bucket
+}
+
+// remaining returns the retries left by the ratio, it must be called with the lock held.
+func (b *retryBudget) remaining(now time.Time) float64 {
+	oldest := now.UnixNano()/int64(b.bucketDur) - _retryBudgetBuckets
+	var requests, retries int64
+	for _, bucket := range b.buckets {
+		if bucket.epoch <= oldest {
+			continue
+		}
+		requests += bucket.requests
+		retries += bucket.retries
+	}
+	return b.ratio*float64(requests) - float64(retries)
+}
+
+// Request records an original request.
+func (b *retryBudget) Request() {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	b.current(now).requests++
+	b.gauge.Set(b.remaining(now) + b.minimum(now))
+}
+
+// Retry consumes one retry from the budget if there is any left,
+// the ratio is spent before the minimum.
+func (b *retryBudget) Retry() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	now := b.now()
+	remaining, minimum := b.remaining(now), b.minimum(now)
+	switch {
+	case remaining >= 1:
+		b.current(now).retries++
+		remaining--
+	case minimum >= 1:
+		b.minTokens--
+		minimum--
+	default:
+		b.gauge.Set(remaining + minimum)
+		return false
+	}
+	b.gauge.Set(remaining + minimum)
+	return true
+}
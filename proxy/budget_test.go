@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget("test")
+	budget.configure(&config.RetryBudget{Ratio: 0.2, Window: time.Minute, MinRetriesPerSecond: 0})
+	for i := 0; i < 10; i++ {
+		budget.Request()
+	}
+	allowed := 0
+	for i := 0; i < 10; i++ {
+		if budget.Retry() {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Errorf("expected 2 retries allowed, got %d", allowed)
+	}
+
+	// the minimum applies without any request, it refills over time
+	// but is never spent in a burst
+	now := time.Unix(1000, 0)
+	budget = newRetryBudget("test")
+	budget.now = func() time.Time { return now }
+	budget.configure(&config.RetryBudget{Window: 10 * time.Second, MinRetriesPerSecond: 3})
+	retries := func(count int) int {
+		allowed := 0
+		for i := 0; i < count; i++ {
+			if budget.Retry() {
+				allowed++
+			}
+		}
+		return allowed
+	}
+	if allowed := retries(30); allowed != 3 {
+		t.Errorf("expected the 3 retries of a bucket allowed at once, got %d", allowed)
+	}
+	now = now.Add(400 * time.Millisecond)
+	if allowed := retries(10); allowed != 1 {
+		t.Errorf("expected 1 retry after 400ms, got %d", allowed)
+	}
+	now = now.Add(time.Minute)
+	if allowed := retries(10); allowed != 3 {
+		t.Errorf("expected the minimum not to accumulate past a bucket, got %d", allowed)
+	}
+}
+
+func TestPruneRetryBudgets(t *testing.T) {
+	budgets := &retryBudgets{budgets: map[string]*retryBudget{}}
+	kept := config.Endpoint{Method: "GET", Path: "/kept", Retry: &config.Retry{Budget: &config.RetryBudget{Ratio: 0.2}}}
+	dropped := config.Endpoint{Method: "GET", Path: "/dropped", Retry: &config.Retry{Budget: &config.RetryBudget{Ratio: 0.2}}}
+	budget := budgets.get(retryBudgetKey(&kept), kept.Retry.Budget)
+	budgets.get(retryBudgetKey(&dropped), dropped.Retry.Budget).Request()
+
+	budgets.prune([]config.Endpoint{kept, {Method: "GET", Path: "/other"}})
+	if len(budgets.budgets) != 1 || budgets.get(retryBudgetKey(&kept), kept.Retry.Budget) != budget {
+		t.Fatalf("expected only the budget of the kept endpoint, got %v", budgets.budgets)
+	}
+	if _metricRetryBudgetRemaining.DeleteLabelValues(retryBudgetKey(&dropped)) {
+		t.Fatal("expected the gauge of the dropped budget to be deleted")
+	}
+}
+
+func TestValidateRetryBudgets(t *testing.T) {
+	budget := func(ratio float64) *config.Retry {
+		return &config.Retry{Budget: &config.RetryBudget{Ratio: ratio}}
+	}
+	service := map[string]string{"service": "users"}
+	endpoints := []config.Endpoint{
+		{Path: "/a", Metadata: service, Retry: budget(0.2)},
+		{Path: "/b", Metadata: service, Retry: budget(0.2)},
+		{Path: "/c", Metadata: map[string]string{"service": "orders"}, Retry: budget(0.5)},
+		{Path: "/d", Metadata: service},
+	}
+	if err := validateRetryBudgets(endpoints); err != nil {
+		t.Fatal(err)
+	}
+	endpoints = append(endpoints, config.Endpoint{Path: "/e", Metadata: service, Retry: budget(0.5)})
+	if err := validateRetryBudgets(endpoints); err == nil {
+		t.Fatal("expected conflicting budgets of a service to fail")
+	}
+}
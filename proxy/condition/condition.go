@@ -1,12 +1,18 @@
 package condition
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/limes-cloud/gateway/config"
+	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"syscall"
+
+	"github.com/go-kratos/kratos/v2/selector"
 )
 
 type Condition interface {
@@ -14,6 +20,12 @@ type Condition interface {
 	Judge(*http.Response) bool
 }
 
+// ErrorCondition is a condition judging a failed round trip.
+type ErrorCondition interface {
+	Condition
+	JudgeError(error) bool
+}
+
 type byStatusCode struct {
 	StatusCode  string
 	parsedCodes []int64
@@ -81,6 +93,90 @@ func (c *byHeader) Prepare() error {
 	return nil
 }
 
+const (
+	// TransportConnect is any failure to establish the connection, the request has not been sent.
+	TransportConnect = "connect"
+	// TransportConnectRefused is a connection refused by the upstream.
+	TransportConnectRefused = "connect_refused"
+	// TransportReset is a connection reset by the upstream.
+	TransportReset = "reset"
+	// TransportTimeout is a dial, read or write timeout.
+	TransportTimeout = "timeout"
+)
+
+var transportErrorJudges = map[string]func(error) bool{
+	TransportConnect:        IsConnectError,
+	TransportConnectRefused: IsConnectionRefused,
+	TransportReset:          IsConnectionReset,
+	TransportTimeout:        IsTimeout,
+}
+
+type byTransportError struct {
+	TransportError string
+	parsed         []func(error) bool
+}
+
+func (c *byTransportError) Prepare() error {
+	kinds := []string{c.TransportError}
+	if strings.HasPrefix(c.TransportError, "[") {
+		values, err := parseAsStringList(c.TransportError)
+		if err != nil {
+			return err
+		}
+		kinds = values
+	}
+	c.parsed = make([]func(error) bool, 0, len(kinds))
+	for _, kind := range kinds {
+		judge, ok := transportErrorJudges[strings.ToLower(kind)]
+		if !ok {
+			return fmt.Errorf("invalid transport error condition %s", kind)
+		}
+		c.parsed = append(c.parsed, judge)
+	}
+	return nil
+}
+
+func (c *byTransportError) Judge(*http.Response) bool {
+	return false
+}
+
+func (c *byTransportError) JudgeError(err error) bool {
+	for _, judge := range c.parsed {
+		if judge(err) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsConnectError reports whether the request failed before it was sent.
+func IsConnectError(err error) bool {
+	if errors.Is(err, selector.ErrNoAvailable) {
+		return true
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// IsConnectionRefused reports whether the upstream refused the connection.
+func IsConnectionRefused(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// IsConnectionReset reports whether the upstream reset the connection.
+func IsConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE)
+}
+
+// IsTimeout reports whether the round trip timed out.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 func parseAsStringList(in string) ([]string, error) {
 	var out []string
 	if err := json.Unmarshal([]byte(in), &out); err != nil {
@@ -103,6 +199,17 @@ func ParseConditon(in []config.Condition) ([]Condition, error) {
 			continue
 		}
 
+		if rawCond.TransportError != "" {
+			cond := &byTransportError{
+				TransportError: rawCond.TransportError,
+			}
+			if err := cond.Prepare(); err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, cond)
+			continue
+		}
+
 		if rawCond.StatusCode != "" {
 			cond := &byStatusCode{
 				StatusCode: rawCond.StatusCode,
@@ -129,3 +236,23 @@ func JudgeConditons(conditions []Condition, resp *http.Response, onEmpty bool) b
 	}
 	return false
 }
+
+// JudgeErrorConditons judges a failed round trip, onEmpty is returned
+// when there is no error condition.
+func JudgeErrorConditons(conditions []Condition, err error, onEmpty bool) bool {
+	judged := false
+	for _, cond := range conditions {
+		errCond, ok := cond.(ErrorCondition)
+		if !ok {
+			continue
+		}
+		judged = true
+		if errCond.JudgeError(err) {
+			return true
+		}
+	}
+	if !judged {
+		return onEmpty
+	}
+	return false
+}
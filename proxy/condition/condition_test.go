@@ -0,0 +1,81 @@
+package condition
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+var (
+	errRefused = fmt.Errorf("round trip: %w", &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)})
+	errReset   = fmt.Errorf("round trip: %w", &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)})
+)
+
+func TestTransportErrors(t *testing.T) {
+	tests := []struct {
+		err                     error
+		connect, refused, reset bool
+		timeout                 bool
+	}{
+		{err: errRefused, connect: true, refused: true},
+		{err: errReset, reset: true},
+		{err: &net.OpError{Op: "write", Net: "tcp", Err: syscall.EPIPE}, reset: true},
+		{err: selector.ErrNoAvailable, connect: true},
+		{err: context.DeadlineExceeded, timeout: true},
+		{err: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		if got := IsConnectError(tt.err); got != tt.connect {
+			t.Errorf("%v: expected connect %v, got %v", tt.err, tt.connect, got)
+		}
+		if got := IsConnectionRefused(tt.err); got != tt.refused {
+			t.Errorf("%v: expected refused %v, got %v", tt.err, tt.refused, got)
+		}
+		if got := IsConnectionReset(tt.err); got != tt.reset {
+			t.Errorf("%v: expected reset %v, got %v", tt.err, tt.reset, got)
+		}
+		if got := IsTimeout(tt.err); got != tt.timeout {
+			t.Errorf("%v: expected timeout %v, got %v", tt.err, tt.timeout, got)
+		}
+	}
+}
+
+func TestJudgeErrorConditons(t *testing.T) {
+	if _, err := ParseConditon([]config.Condition{{TransportError: "broken"}}); err == nil {
+		t.Fatal("expected an unknown transport error to fail")
+	}
+	conditions, err := ParseConditon([]config.Condition{
+		{StatusCode: "502"},
+		{TransportError: `["connect_refused","timeout"]`},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !JudgeErrorConditons(conditions, errRefused, false) {
+		t.Fatal("expected a refused connection to match")
+	}
+	if JudgeErrorConditons(conditions, errReset, true) {
+		t.Fatal("expected a reset connection not to match")
+	}
+	if !JudgeConditons(conditions, &http.Response{StatusCode: http.StatusBadGateway}, false) {
+		t.Fatal("expected the status code to match")
+	}
+
+	// without transport error condition every error is judged as onEmpty
+	conditions, err = ParseConditon([]config.Condition{{StatusCode: "500-504"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !JudgeErrorConditons(conditions, errReset, true) || JudgeErrorConditons(conditions, errReset, false) {
+		t.Fatal("expected the errors to be judged as onEmpty")
+	}
+}
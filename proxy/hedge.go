@@ -0,0 +1,167 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+type hedgeResult struct {
+	attempt int
+	opts    *middleware.RequestOptions
+	resp    *http.Response
+	err     error
+}
+
+// discard releases the response of an attempt that is not used.
+func (r *hedgeResult) discard(ctx context.Context) {
+	if r.resp == nil {
+		return
+	}
+	if r.resp.Body != nil {
+		r.resp.Body.Close()
+	}
+	r.opts.DoneFunc(ctx, selector.DoneInfo{Err: context.Canceled})
+}
+
+// hedgeTracker collects the backends picked by the concurrent attempts.
+type hedgeTracker struct {
+	lock     sync.Mutex
+	backends []string
+}
+
+func (t *hedgeTracker) add(backend string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.backends = append(t.backends, backend)
+}
+
+func (t *hedgeTracker) list() []string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return append([]string(nil), t.backends...)
+}
+
+type hedger struct {
+	tripper      http.RoundTripper
+	strategy     *retryStrategy
+	interceptors *interceptors
+	labels       middleware.MetricsLabels
+	allow        func(attempt int) bool
+	markSuccess  func(attempt int)
+	markFailed   func(attempt int, err error)
+}
+
+// do sends the request to one node and, whenever no response arrived within
+// the hedge delay, sends a parallel attempt to a node that has not been tried yet.
+// A failed attempt is retried immediately. The first successful response wins
+// and the other attempts are cancelled, the returned cancel function releases
+// the winning attempt once its response has been consumed.
+func (h *hedger) do(ctx context.Context, req *http.Request, body *bodyBuffer, reqOpts *middleware.RequestOptions) (*http.Response, context.CancelFunc, error) {
+	attempts := h.strategy.attempts
+	tracker := &hedgeTracker{}
+	results := make(chan *hedgeResult, attempts)
+	cancels := make([]context.CancelFunc, 0, attempts)
+	hedged := make([]bool, attempts)
+	launch := func(i int) {
+		opts := middleware.NewAttemptOptions(reqOpts)
+		opts.Backends = append(opts.Backends, tracker.list()...)
+		opts.OnSelected = tracker.add
+		opts.LastAttempt = i+1 >= attempts
+		tryCtx, cancel := h.interceptors.prepareAttemptTimeoutContext(middleware.NewRequestContext(ctx, opts), req, h.strategy.perTryTimeout)
+		cancels = append(cancels, cancel)
+		outreq := req.Clone(tryCtx)
+		outreq.Body = body.Reader()
+		go func() {
+			resp, err := h.tripper.RoundTrip(outreq)
+			results <- &hedgeResult{attempt: i, opts: opts, resp: resp, err: err}
+		}()
+	}
+
+	launch(0)
+	launched, pending := 1, 1
+	timer := time.NewTimer(h.strategy.hedgeDelay)
+	defer timer.Stop()
+	var winner, last *hedgeResult
+	for pending > 0 && winner == nil {
+		select {
+		case r := <-results:
+			pending--
+			if r.err == nil && !judgeRetryRequired(h.strategy.conditions, r.resp) {
+				winner = r
+				h.markSuccess(r.attempt)
+				continue
+			}
+			if r.err != nil {
+				h.markFailed(r.attempt, r.err)
+				log.Errorf("Attempt at [%d/%d], failed to handle request: %s: %+v", r.attempt+1, attempts, req.URL.String(), r.err)
+			} else {
+				h.markFailed(r.attempt, errors.New("assertion failed"))
+			}
+			if last != nil {
+				last.discard(ctx)
+				cancels[last.attempt]()
+			}
+			last = r
+			if r.err != nil && !h.strategy.retryOnError(req, r.err) {
+				continue
+			}
+			if launched < attempts && ctx.Err() == nil && h.allow(launched) {
+				launch(launched)
+				launched++
+				pending++
+			}
+		case <-timer.C:
+			if launched < attempts && ctx.Err() == nil && h.allow(launched) {
+				hedged[launched] = true
+				launch(launched)
+				launched++
+				pending++
+				timer.Reset(h.strategy.hedgeDelay)
+			}
+		}
+	}
+
+	result := winner
+	if result == nil {
+		result = last
+	} else if last != nil {
+		// the failed attempt kept in case every attempt fails
+		last.discard(ctx)
+	}
+	for i, cancel := range cancels {
+		if i != result.attempt {
+			cancel()
+		}
+	}
+	if pending > 0 {
+		go func(pending int) {
+			for ; pending > 0; pending-- {
+				(<-results).discard(ctx)
+			}
+		}(pending)
+	}
+	for i := 1; i < launched; i++ {
+		if hedged[i] {
+			hedgeStateIncr(h.labels, winner != nil && winner.attempt == i)
+		}
+	}
+
+	reqOpts.Backends = tracker.list()
+	reqOpts.UpstreamStatusCode = result.opts.UpstreamStatusCode
+	reqOpts.UpstreamResponseTime = result.opts.UpstreamResponseTime
+	reqOpts.CurrentNode = result.opts.CurrentNode
+	reqOpts.DoneFunc = result.opts.DoneFunc
+	reqOpts.LastAttempt = true
+	for k, v := range result.opts.Metadata {
+		reqOpts.Metadata[k] = v
+	}
+	return result.resp, cancels[result.attempt], result.err
+}
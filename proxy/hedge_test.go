@@ -0,0 +1,143 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+// trackedAttempt records how the response of an attempt has been released.
+type trackedAttempt struct {
+	start  time.Time
+	closed atomic.Bool
+	done   atomic.Bool
+}
+
+type trackedBody struct {
+	io.Reader
+	attempt *trackedAttempt
+}
+
+func (b *trackedBody) Close() error {
+	b.attempt.closed.Store(true)
+	return nil
+}
+
+type attemptTracker struct {
+	lock     sync.Mutex
+	attempts []*trackedAttempt
+}
+
+// respond records the attempt and returns a response released through it.
+func (a *attemptTracker) respond(req *http.Request, status int, body string) *http.Response {
+	attempt := &trackedAttempt{start: time.Now()}
+	a.lock.Lock()
+	a.attempts = append(a.attempts, attempt)
+	a.lock.Unlock()
+	reqOpt, _ := middleware.FromRequestContext(req.Context())
+	reqOpt.DoneFunc = func(context.Context, selector.DoneInfo) { attempt.done.Store(true) }
+	return &http.Response{
+		StatusCode: status,
+		Header:     http.Header{},
+		Body:       &trackedBody{Reader: strings.NewReader(body), attempt: attempt},
+	}
+}
+
+func (a *attemptTracker) list() []*trackedAttempt {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return append([]*trackedAttempt(nil), a.attempts...)
+}
+
+func waitReleased(t *testing.T, attempt *trackedAttempt) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !attempt.closed.Load() || !attempt.done.Load() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the attempt to be released, closed %v done %v", attempt.closed.Load(), attempt.done.Load())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func hedgedEndpoint(delay time.Duration) config.Endpoint {
+	return config.Endpoint{Path: "/hedge", Protocol: "HTTP", Timeout: 5 * time.Second, Retry: &config.Retry{
+		Count:      3,
+		Conditions: []config.Condition{{StatusCode: "503"}},
+		Hedging:    &config.Hedging{Delay: delay},
+	}}
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestHedgeDelay(t *testing.T) {
+	tracker := &attemptTracker{}
+	var calls atomic.Int64
+	srv := newFakeProxy(t, func(req *http.Request) (*http.Response, error) {
+		if calls.Add(1) == 1 {
+			resp := tracker.respond(req, http.StatusOK, "slow")
+			// the first attempt only answers once it has been cancelled
+			<-req.Context().Done()
+			return resp, nil
+		}
+		return tracker.respond(req, http.StatusOK, "fast"), nil
+	}, hedgedEndpoint(50*time.Millisecond))
+
+	code, body := get(t, srv.URL+"/hedge")
+	if code != http.StatusOK || body != "fast" {
+		t.Fatalf("expected the hedged attempt to win, got %d %q", code, body)
+	}
+	attempts := tracker.list()
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(attempts))
+	}
+	// the first attempt is timed once it reaches the backend, after the delay started
+	if d := attempts[1].start.Sub(attempts[0].start); d < 45*time.Millisecond {
+		t.Fatalf("expected the hedged attempt after the delay, got %s", d)
+	}
+	// the slow loser is released once it answers, the winner once consumed
+	waitReleased(t, attempts[0])
+	waitReleased(t, attempts[1])
+}
+
+func TestHedgeFailedAttemptReleased(t *testing.T) {
+	tracker := &attemptTracker{}
+	var calls atomic.Int64
+	srv := newFakeProxy(t, func(req *http.Request) (*http.Response, error) {
+		if calls.Add(1) == 1 {
+			return tracker.respond(req, http.StatusServiceUnavailable, "failed"), nil
+		}
+		return tracker.respond(req, http.StatusOK, "ok"), nil
+	}, hedgedEndpoint(time.Second))
+
+	code, body := get(t, srv.URL+"/hedge")
+	if code != http.StatusOK || body != "ok" {
+		t.Fatalf("expected the retry to win, got %d %q", code, body)
+	}
+	attempts := tracker.list()
+	if len(attempts) != 2 {
+		t.Fatalf("expected 2 attempts, got %d", len(attempts))
+	}
+	// the failed attempt is kept until another one wins, then released
+	waitReleased(t, attempts[0])
+	waitReleased(t, attempts[1])
+}
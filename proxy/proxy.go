@@ -1,7 +1,6 @@
 package proxy
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -19,15 +18,16 @@ import (
 	"github.com/go-kratos/aegis/circuitbreaker/sre"
 	"github.com/go-kratos/kratos/v2/log"
 	"github.com/go-kratos/kratos/v2/selector"
-	"github.com/go-kratos/kratos/v2/transport/http/status"
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/limes-cloud/gateway/client"
 	"github.com/limes-cloud/gateway/config"
 	"github.com/limes-cloud/gateway/consts"
 	"github.com/limes-cloud/gateway/middleware"
+	"github.com/limes-cloud/gateway/proxy/render"
 	"github.com/limes-cloud/gateway/router"
 	"github.com/limes-cloud/gateway/router/mux"
+	"github.com/limes-cloud/gateway/utils/clientip"
 )
 
 var (
@@ -62,12 +62,19 @@ var (
 		Name:      "requests_retry_state",
 		Help:      "Total request retries",
 	}, []string{"protocol", "method", "path", "service", "basePath", "success"})
+	_metricHedgeState = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "go",
+		Subsystem: "gateway",
+		Name:      "requests_hedge_state",
+		Help:      "Total hedged requests",
+	}, []string{"protocol", "method", "path", "service", "basePath", "won"})
 )
 
 func init() {
 	prometheus.MustRegister(_metricRequestsTotal)
 	prometheus.MustRegister(_metricRequestsDuration)
 	prometheus.MustRegister(_metricRetryState)
+	prometheus.MustRegister(_metricHedgeState)
 	prometheus.MustRegister(_metricSentBytes)
 	prometheus.MustRegister(_metricReceivedBytes)
 }
@@ -89,35 +96,33 @@ func setXFFHeader(req *http.Request) {
 	}
 }
 
-func writeError(w http.ResponseWriter, r *http.Request, err error, labels middleware.MetricsLabels) {
+func writeError(w http.ResponseWriter, r *http.Request, e *config.Endpoint, err error, labels middleware.MetricsLabels) {
 	var statusCode int
 	switch {
 	case errors.Is(err, context.Canceled),
 		err.Error() == "client disconnected":
-		statusCode = 499
+		statusCode = render.StatusClientClosedRequest
 	case errors.Is(err, context.DeadlineExceeded):
 		statusCode = 504
+	case errors.Is(err, errBodyTooLarge):
+		statusCode = 413
 	default:
 		log.Errorf("Failed to handle request: %s: %+v", r.URL.String(), err)
 		statusCode = 502
 	}
 	requestsTotalIncr(labels, statusCode)
+	message := ""
 	if labels.Protocol() == consts.GRPC {
-		// see https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
-		code := strconv.Itoa(int(status.ToGRPCCode(statusCode)))
-		w.Header().Set("Content-Type", "application/grpc")
-		w.Header().Set("Grpc-Status", code)
-		w.Header().Set("Grpc-Message", err.Error())
-		statusCode = 200
+		message = err.Error()
 	}
-	w.WriteHeader(statusCode)
+	render.Write(w, r, e, render.NewError(statusCode, message))
 }
 
 // notFoundHandler replies to the request with an HTTP 404 not found error.
 func notFoundHandler(w http.ResponseWriter, r *http.Request) {
 	code := http.StatusNotFound
 	message := "404 page not found"
-	http.Error(w, message, code)
+	render.Write(w, r, nil, render.NewError(code, message))
 	log.Context(r.Context()).Errorw(
 		"source", "accesslog",
 		"host", r.Host,
@@ -134,7 +139,7 @@ func notFoundHandler(w http.ResponseWriter, r *http.Request) {
 func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request) {
 	code := http.StatusMethodNotAllowed
 	message := http.StatusText(code)
-	http.Error(w, message, code)
+	render.Write(w, r, nil, render.NewError(code, message))
 	log.Context(r.Context()).Errorw(
 		"source", "accesslog",
 		"host", r.Host,
@@ -161,6 +166,7 @@ func (i *interceptors) SetPrepareAttemptTimeoutContext(f func(ctx context.Contex
 // Proxy is a gateway proxy.
 type Proxy struct {
 	router            atomic.Value
+	clientIP          atomic.Pointer[clientip.Resolver]
 	clientFactory     client.Factory
 	Interceptors      interceptors
 	middlewareFactory middleware.FactoryV2
@@ -238,6 +244,7 @@ func (p *Proxy) buildEndpoint(e *config.Endpoint, ms []config.Middleware) (_ htt
 	labels := middleware.NewMetricsLabels(e)
 	markSuccessStat, markFailedStat := splitRetryMetricsHandler(e)
 	retryBreaker := sre.NewBreaker(sre.WithSuccess(0.8))
+	retryBudget := prepareRetryBudget(e)
 	markSuccess := func(i int) {
 		markSuccessStat(i)
 		if i > 0 {
@@ -250,11 +257,37 @@ func (p *Proxy) buildEndpoint(e *config.Endpoint, ms []config.Middleware) (_ htt
 			retryBreaker.MarkFailed()
 		}
 	}
+	var hedge *hedger
+	if retryStrategy.hedgeDelay > 0 {
+		hedge = &hedger{
+			tripper:      tripper,
+			strategy:     retryStrategy,
+			interceptors: &p.Interceptors,
+			labels:       labels,
+			markSuccess:  markSuccess,
+			markFailed:   markFailed,
+			allow: func(i int) bool {
+				if !retryFeature.Enabled() {
+					return false
+				}
+				if err := retryBreaker.Allow(); err != nil {
+					markFailed(i, err)
+					return false
+				}
+				if retryBudget != nil && !retryBudget.Retry() {
+					markFailed(i, errRetryBudgetExhausted)
+					return false
+				}
+				return true
+			},
+		}
+	}
 	return http.Handler(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		startTime := time.Now()
 		setXFFHeader(req)
 
 		reqOpts := middleware.NewRequestOptions(e)
+		reqOpts.ClientIP = p.resolveClientIP(req)
 		ctx := middleware.NewRequestContext(req.Context(), reqOpts)
 		var cancel context.CancelFunc
 		if retryStrategy.timeout > 0 {
@@ -267,27 +300,82 @@ func (p *Proxy) buildEndpoint(e *config.Endpoint, ms []config.Middleware) (_ htt
 			requestsDurationObserve(labels, time.Since(startTime).Seconds())
 		}()
 
-		body, err := io.ReadAll(req.Body)
-		if err != nil {
-			writeError(w, req, err, labels)
+		if e.Protocol != consts.GRPC && upgradeType(req.Header) != "" {
+			serveUpgrade(ctx, w, req, tripper, reqOpts, labels)
 			return
 		}
-		receivedBytesAdd(labels, int64(len(body)))
-		req.GetBody = func() (io.ReadCloser, error) {
-			reader := bytes.NewReader(body)
-			return io.NopCloser(reader), nil
+		if retryBudget != nil {
+			retryBudget.Request()
+		}
+		if exceedsMaxBodySize(req, e.MaxBodySize) {
+			writeError(w, req, e, errBodyTooLarge, labels)
+			return
+		}
+		// the body is only buffered when it may be replayed by a retry,
+		// otherwise it is streamed straight to the upstream.
+		var body *bodyBuffer
+		if retryStrategy.attempts > 1 && retryFeature.Enabled() {
+			buffered, err := bufferBody(req.Body, e.MaxBodySize)
+			if err != nil {
+				writeError(w, req, e, err, labels)
+				return
+			}
+			defer buffered.Close()
+			body = buffered
+			receivedBytesAdd(labels, body.Size())
+			if req.ContentLength < 0 {
+				req.ContentLength = body.Size()
+			}
+			req.GetBody = func() (io.ReadCloser, error) {
+				return body.Reader(), nil
+			}
+		} else if req.Body != nil && req.Body != http.NoBody {
+			stream := &countingReader{ReadCloser: req.Body, limit: e.MaxBodySize}
+			req.Body = stream
+			req.GetBody = nil
+			defer func() {
+				receivedBytesAdd(labels, stream.Count())
+			}()
 		}
 
 		var resp *http.Response
-		for i := 0; i < retryStrategy.attempts; i++ {
+		var err error
+		hedged := hedge != nil && body != nil && retryStrategy.idempotent(req)
+		if hedged {
+			var release context.CancelFunc
+			resp, release, err = hedge.do(ctx, req, body, reqOpts)
+			defer release()
+		}
+		for i := 0; !hedged && i < retryStrategy.attempts; i++ {
 			if i > 0 {
-				if !retryFeature.Enabled() {
+				if !retryFeature.Enabled() || body == nil {
 					break
 				}
 				if err := retryBreaker.Allow(); err != nil {
 					markFailed(i, err)
 					break
 				}
+				delay, ok := retryStrategy.backoffDelay(ctx, i, resp)
+				if !ok {
+					break
+				}
+				if retryBudget != nil && !retryBudget.Retry() {
+					markFailed(i, errRetryBudgetExhausted)
+					break
+				}
+				if delay > 0 {
+					reqOpts.RetryDelays = append(reqOpts.RetryDelays, delay)
+					sleepContext(ctx, delay)
+				}
+				if resp != nil {
+					// discard the response of the previous attempt
+					if resp.Body != nil {
+						resp.Body.Close()
+					}
+					reqOpts.DoneFunc(ctx, selector.DoneInfo{Err: errRetryDiscarded, ReplyMD: resp.Trailer})
+					reqOpts.DoneFunc = func(context.Context, selector.DoneInfo) {}
+					resp = nil
+				}
 			}
 
 			if (i + 1) >= retryStrategy.attempts {
@@ -301,12 +389,16 @@ func (p *Proxy) buildEndpoint(e *config.Endpoint, ms []config.Middleware) (_ htt
 
 			tryCtx, cancel := p.Interceptors.prepareAttemptTimeoutContext(ctx, req, retryStrategy.perTryTimeout)
 			defer cancel()
-			reader := bytes.NewReader(body)
-			req.Body = io.NopCloser(reader)
+			if body != nil {
+				req.Body = body.Reader()
+			}
 			resp, err = tripper.RoundTrip(req.Clone(tryCtx))
 			if err != nil {
 				markFailed(i, err)
 				log.Errorf("Attempt at [%d/%d], failed to handle request: %s: %+v", i+1, retryStrategy.attempts, req.URL.String(), err)
+				if !retryStrategy.retryOnError(req, err) {
+					break
+				}
 				continue
 			}
 			if !judgeRetryRequired(retryStrategy.conditions, resp) {
@@ -315,10 +407,13 @@ func (p *Proxy) buildEndpoint(e *config.Endpoint, ms []config.Middleware) (_ htt
 				break
 			}
 			markFailed(i, errors.New("assertion failed"))
+			if !retryStrategy.idempotent(req) {
+				break
+			}
 			// continue the retry loop
 		}
 		if err != nil {
-			writeError(w, req, err, labels)
+			writeError(w, req, e, err, labels)
 			return
 		}
 
@@ -377,7 +472,8 @@ func (p *Proxy) buildEndpoint(e *config.Endpoint, ms []config.Middleware) (_ htt
 			}
 
 			sent := int64(0)
-			if reqOpts.Endpoint.ResponseFormat && hasJson {
+			// the responses rendered by the gateway are already formatted
+			if reqOpts.Endpoint.ResponseFormat && hasJson && !render.Rendered(resp) {
 				body := ResponseFormat(resp)
 				sent = int64(len(body))
 				_, err = w.Write(body)
@@ -424,6 +520,10 @@ func requestsDurationObserve(labels middleware.MetricsLabels, seconds float64) {
 	_metricRequestsDuration.WithLabelValues(labels.Protocol(), labels.Method(), labels.Path(), labels.Service(), labels.BasePath()).Observe(seconds)
 }
 
+func hedgeStateIncr(labels middleware.MetricsLabels, won bool) {
+	_metricHedgeState.WithLabelValues(labels.Protocol(), labels.Method(), labels.Path(), labels.Service(), labels.BasePath(), strconv.FormatBool(won)).Inc()
+}
+
 func retryStateIncr(labels middleware.MetricsLabels, success bool) {
 	if success {
 		_metricRetryState.WithLabelValues(labels.Protocol(), labels.Method(), labels.Path(), labels.Service(), labels.BasePath(), "true").Inc()
@@ -439,23 +539,42 @@ func closeOnError(closer io.Closer, err *error) {
 	closer.Close()
 }
 
+// resolveClientIP returns the client address behind the trusted proxies.
+func (p *Proxy) resolveClientIP(req *http.Request) string {
+	if r := p.clientIP.Load(); r != nil {
+		return r.Resolve(req)
+	}
+	return clientip.RemoteIP(req)
+}
+
 // Update updates service endpoint.
 func (p *Proxy) Update(c *config.Config) (retError error) {
+	trusted, err := clientip.ParsePrefixes(c.TrustedProxies)
+	if err != nil {
+		return fmt.Errorf("invalid trusted proxies: %w", err)
+	}
+	if err := validateRetryBudgets(c.Endpoints); err != nil {
+		return err
+	}
+	// the resolver is only stored along with the router, a failed reload keeps both
+	resolver := clientip.NewResolver(trusted)
 	router := mux.NewRouter(http.HandlerFunc(notFoundHandler), http.HandlerFunc(methodNotAllowedHandler))
-	for _, e := range c.Endpoints {
+	for _, e := range mux.SortEndpoints(c.Endpoints) {
 		ep := e
 		handler, closer, err := p.buildEndpoint(&ep, c.Middlewares)
 		if err != nil {
 			return err
 		}
 		defer closeOnError(closer, &retError)
-		if err = router.Handle(e.Path, e.Method, e.Host, handler, closer); err != nil {
+		if err = router.Handle(e.Path, e.Method, e.Host, e.Match, handler, closer); err != nil {
 			return err
 		}
 		log.Infof("build endpoint: [%s] %s %s", e.Protocol, e.Method, e.Path)
 	}
+	p.clientIP.Store(resolver)
 	old := p.router.Swap(router)
 	tryCloseRouter(old)
+	globalRetryBudgets.prune(c.Endpoints)
 	return nil
 }
 
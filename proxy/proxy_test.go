@@ -0,0 +1,84 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/limes-cloud/gateway/client"
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+// newTestProxy serves the endpoints through a proxy with static backends.
+func newTestProxy(t *testing.T, endpoints ...config.Endpoint) *httptest.Server {
+	t.Helper()
+	return newTestProxyWith(t, client.NewFactory(nil), endpoints...)
+}
+
+// fakeClient sends the requests of every endpoint to a function.
+type fakeClient func(req *http.Request) (*http.Response, error)
+
+func (f fakeClient) RoundTrip(req *http.Request) (*http.Response, error) { return f(req) }
+func (f fakeClient) Close() error                                        { return nil }
+
+// newFakeProxy serves the endpoints through a proxy sending the requests to fn.
+func newFakeProxy(t *testing.T, fn fakeClient, endpoints ...config.Endpoint) *httptest.Server {
+	t.Helper()
+	return newTestProxyWith(t, func(*config.Endpoint) (client.Client, error) { return fn, nil }, endpoints...)
+}
+
+func newTestProxyWith(t *testing.T, factory client.Factory, endpoints ...config.Endpoint) *httptest.Server {
+	t.Helper()
+	p, err := New(factory, middleware.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Update(&config.Config{Endpoints: endpoints}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(p)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func backendOf(srv *httptest.Server) []config.Backend {
+	return []config.Backend{{Target: strings.TrimPrefix(srv.URL, "http://")}}
+}
+
+func TestUpdateKeepsClientIP(t *testing.T) {
+	factory := func(e *config.Endpoint) (client.Client, error) {
+		if e.Path == "/broken" {
+			return nil, errors.New("broken endpoint")
+		}
+		return fakeClient(func(*http.Request) (*http.Response, error) { return nil, nil }), nil
+	}
+	p, err := New(factory, middleware.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint := config.Endpoint{Path: "/ok", Protocol: "HTTP"}
+	if err := p.Update(&config.Config{TrustedProxies: []string{"10.0.0.0/8"}, Endpoints: []config.Endpoint{endpoint}}); err != nil {
+		t.Fatal(err)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/ok", nil)
+	req.RemoteAddr = "10.0.0.1:1234"
+	req.Header.Set("X-Forwarded-For", "192.168.0.1")
+
+	// the trusted proxies of a failed reload are not applied
+	broken := config.Endpoint{Path: "/broken", Protocol: "HTTP"}
+	if err := p.Update(&config.Config{Endpoints: []config.Endpoint{endpoint, broken}}); err == nil {
+		t.Fatal("expected the reload to fail")
+	}
+	if got := p.resolveClientIP(req); got != "192.168.0.1" {
+		t.Fatalf("expected the previous trusted proxies to be kept, got %s", got)
+	}
+	if err := p.Update(&config.Config{Endpoints: []config.Endpoint{endpoint}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.resolveClientIP(req); got != "10.0.0.1" {
+		t.Fatalf("expected the trusted proxies to be reloaded, got %s", got)
+	}
+}
@@ -0,0 +1,178 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/go-kratos/kratos/v2/middleware/tracing"
+	"github.com/go-kratos/kratos/v2/transport/http/status"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/consts"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+// StatusClientClosedRequest is the non-standard status of a request cancelled by the client.
+const StatusClientClosedRequest = 499
+
+// Response is the response envelope of the gateway.
+type Response struct {
+	Code     int32             `json:"code,omitempty"`
+	Reason   string            `json:"reason,omitempty"`
+	Data     any               `json:"data"`
+	Message  string            `json:"message,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	TraceID  string            `json:"traceId,omitempty"`
+}
+
+var _reasons = map[int]string{
+	StatusClientClosedRequest: "CLIENT_CLOSED_REQUEST",
+}
+
+// Reason returns the default reason of the status code, e.g. TOO_MANY_REQUESTS.
+func Reason(statusCode int) string {
+	if reason, ok := _reasons[statusCode]; ok {
+		return reason
+	}
+	text := http.StatusText(statusCode)
+	if text == "" {
+		return "UNKNOWN"
+	}
+	text = strings.NewReplacer(" ", "_", "-", "_", "'", "").Replace(text)
+	return strings.ToUpper(text)
+}
+
+// Error is an error response generated by the gateway itself.
+type Error struct {
+	StatusCode int
+	Reason     string
+	Message    string
+}
+
+// NewError returns an error with the default reason of the status code,
+// the message defaults to the status text.
+func NewError(statusCode int, message string) *Error {
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	return &Error{
+		StatusCode: statusCode,
+		Reason:     Reason(statusCode),
+		Message:    message,
+	}
+}
+
+// override applies the error responses configured on the endpoint.
+func (e *Error) override(endpoint *config.Endpoint) *Error {
+	if endpoint == nil {
+		return e
+	}
+	for _, o := range endpoint.ErrorResponses {
+		if o.StatusCode != e.StatusCode {
+			continue
+		}
+		out := *e
+		if o.Reason != "" {
+			out.Reason = o.Reason
+		}
+		if o.Message != "" {
+			out.Message = o.Message
+		}
+		return &out
+	}
+	return e
+}
+
+func traceID(req *http.Request) string {
+	if id, _ := tracing.TraceID()(req.Context()).(string); id != "" {
+		return id
+	}
+	return req.Header.Get(consts.TRACE_ID)
+}
+
+// encode returns the status code, headers and body sent to the client.
+// gRPC endpoints get a trailers-only response carrying the mapped grpc status.
+func (e *Error) encode(req *http.Request, endpoint *config.Endpoint) (int, http.Header, []byte) {
+	e = e.override(endpoint)
+	header := http.Header{}
+	if endpoint != nil && endpoint.Protocol == consts.GRPC {
+		// see https://github.com/googleapis/googleapis/blob/master/google/rpc/code.proto
+		header.Set("Content-Type", "application/grpc")
+		header.Set("Grpc-Status", strconv.Itoa(int(status.ToGRPCCode(e.StatusCode))))
+		header.Set("Grpc-Message", e.Message)
+		return http.StatusOK, header, nil
+	}
+	id := traceID(req)
+	body, _ := json.Marshal(Response{
+		Code:    int32(e.StatusCode),
+		Reason:  e.Reason,
+		Message: e.Message,
+		TraceID: id,
+	})
+	if id != "" {
+		header.Set(consts.TRACE_ID, id)
+	}
+	header.Set("Content-Type", "application/json")
+	header.Set("Content-Length", strconv.Itoa(len(body)))
+	return e.StatusCode, header, body
+}
+
+// Write writes the error to the client.
+func Write(w http.ResponseWriter, req *http.Request, endpoint *config.Endpoint, e *Error) {
+	statusCode, header, body := e.encode(req, endpoint)
+	for k, v := range header {
+		w.Header()[k] = v
+	}
+	w.WriteHeader(statusCode)
+	if len(body) > 0 {
+		_, _ = w.Write(body)
+	}
+}
+
+// renderedKey marks a response rendered by the gateway in the request values,
+// the middlewares may still wrap its body.
+type renderedKey struct {
+	resp *http.Response
+}
+
+// Rendered reports whether the response has been rendered by the gateway,
+// its body is already in the shape of the response envelope.
+func Rendered(resp *http.Response) bool {
+	if resp.Request == nil {
+		return false
+	}
+	reqOpt, ok := middleware.FromRequestContext(resp.Request.Context())
+	if !ok {
+		return false
+	}
+	_, ok = reqOpt.Values.Get(renderedKey{resp})
+	return ok
+}
+
+// NewResponse returns the error as the response of a middleware,
+// the endpoint is taken from the request context.
+func NewResponse(req *http.Request, e *Error) *http.Response {
+	var endpoint *config.Endpoint
+	reqOpt, ok := middleware.FromRequestContext(req.Context())
+	if ok {
+		endpoint = reqOpt.Endpoint
+	}
+	statusCode, header, body := e.encode(req, endpoint)
+	resp := &http.Response{
+		Status:        fmt.Sprintf("%d %s", statusCode, http.StatusText(statusCode)),
+		StatusCode:    statusCode,
+		Header:        header,
+		ContentLength: int64(len(body)),
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		Request:       req,
+	}
+	if ok {
+		reqOpt.Values.Set(renderedKey{resp}, struct{}{})
+	}
+	return resp
+}
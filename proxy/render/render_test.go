@@ -0,0 +1,87 @@
+package render
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/consts"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+func TestReason(t *testing.T) {
+	tests := map[int]string{
+		http.StatusTooManyRequests:      "TOO_MANY_REQUESTS",
+		http.StatusRequestURITooLong:    "REQUEST_URI_TOO_LONG",
+		StatusClientClosedRequest:       "CLIENT_CLOSED_REQUEST",
+		http.StatusNonAuthoritativeInfo: "NON_AUTHORITATIVE_INFORMATION",
+		999:                             "UNKNOWN",
+	}
+	for code, expected := range tests {
+		if got := Reason(code); got != expected {
+			t.Errorf("%d: expected %s, got %s", code, expected, got)
+		}
+	}
+}
+
+func decode(t *testing.T, body io.Reader) Response {
+	t.Helper()
+	var res Response
+	if err := json.NewDecoder(body).Decode(&res); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestWrite(t *testing.T) {
+	endpoint := &config.Endpoint{ErrorResponses: []config.ErrorResponse{
+		{StatusCode: http.StatusTooManyRequests, Reason: "SLOW_DOWN"},
+	}}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(consts.TRACE_ID, "trace-1")
+
+	w := httptest.NewRecorder()
+	Write(w, req, endpoint, NewError(http.StatusTooManyRequests, ""))
+	res := decode(t, w.Body)
+	if w.Code != http.StatusTooManyRequests || res.Code != http.StatusTooManyRequests ||
+		res.Reason != "SLOW_DOWN" || res.Message != "Too Many Requests" || res.TraceID != "trace-1" {
+		t.Fatalf("unexpected response: %d %+v", w.Code, res)
+	}
+	if w.Header().Get(consts.TRACE_ID) != "trace-1" || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected headers: %v", w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	Write(w, req, &config.Endpoint{Protocol: consts.GRPC}, NewError(http.StatusServiceUnavailable, "down"))
+	if w.Code != http.StatusOK || w.Header().Get("Grpc-Status") != "14" || w.Header().Get("Grpc-Message") != "down" || w.Body.Len() != 0 {
+		t.Fatalf("unexpected grpc response: %d %v %q", w.Code, w.Header(), w.Body.String())
+	}
+}
+
+func TestNewResponse(t *testing.T) {
+	reqOpt := middleware.NewRequestOptions(&config.Endpoint{Path: "/"})
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req = req.WithContext(middleware.NewRequestContext(context.Background(), reqOpt))
+
+	resp := NewResponse(req, NewError(http.StatusUnauthorized, "no token"))
+	if !Rendered(resp) {
+		t.Fatal("expected the response to be marked as rendered")
+	}
+	res := decode(t, resp.Body)
+	if resp.StatusCode != http.StatusUnauthorized || resp.Status != "401 Unauthorized" || res.Code != http.StatusUnauthorized || res.Reason != "UNAUTHORIZED" || res.Message != "no token" {
+		t.Fatalf("unexpected response: %s %+v", resp.Status, res)
+	}
+
+	// the mark survives a wrapped body but not another response of the request
+	resp.Body = io.NopCloser(resp.Body)
+	if !Rendered(resp) {
+		t.Fatal("expected the wrapped response to stay marked")
+	}
+	if Rendered(&http.Response{Request: req}) {
+		t.Fatal("expected an upstream response not to be marked")
+	}
+}
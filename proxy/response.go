@@ -6,16 +6,11 @@ import (
 	"net/http"
 
 	"github.com/limes-cloud/gateway/consts"
+	"github.com/limes-cloud/gateway/proxy/render"
 )
 
-type Response struct {
-	Code     int32             `json:"code,omitempty"`
-	Reason   string            `json:"reason,omitempty"`
-	Data     any               `json:"data"`
-	Message  string            `json:"message,omitempty"`
-	Metadata map[string]string `json:"metadata,omitempty"`
-	TraceID  string            `json:"traceId,omitempty"`
-}
+// Response is the response envelope of the gateway.
+type Response = render.Response
 
 func ResponseFormat(response *http.Response) []byte {
 	b, _ := io.ReadAll(response.Body)
@@ -35,9 +30,17 @@ func ResponseFormat(response *http.Response) []byte {
 	m, ok := res.(map[string]any)
 
 	if ok && m["code"] != nil && m["reason"] != nil {
-		newRes.Code, _ = m["code"].(int32)
+		// the numbers are decoded as float64
+		if code, ok := m["code"].(float64); ok {
+			newRes.Code = int32(code)
+		}
 		newRes.Message, _ = m["message"].(string)
-		newRes.Metadata, _ = m["metadata"].(map[string]string)
+		if metadata, ok := m["metadata"].(map[string]any); ok {
+			newRes.Metadata = make(map[string]string, len(metadata))
+			for k, v := range metadata {
+				newRes.Metadata[k], _ = v.(string)
+			}
+		}
 		newRes.Reason, _ = m["reason"].(string)
 		newRes.Data, _ = m["data"]
 	} else {
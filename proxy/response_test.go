@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/consts"
+	"github.com/limes-cloud/gateway/proxy/render"
+)
+
+func TestResponseFormat(t *testing.T) {
+	fn := func(req *http.Request) (*http.Response, error) {
+		if req.URL.Path == "/rejected" {
+			return render.NewResponse(req, render.NewError(http.StatusTooManyRequests, "slow down")), nil
+		}
+		w := httptest.NewRecorder()
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(consts.TRACE_ID, "trace-1")
+		if req.URL.Path == "/failed" {
+			w.WriteHeader(http.StatusBadRequest)
+			_, _ = w.Write([]byte(`{"code":400,"reason":"INVALID_NAME","message":"bad name","metadata":{"field":"name"}}`))
+		} else {
+			_, _ = w.Write([]byte(`{"name":"gateway"}`))
+		}
+		return w.Result(), nil
+	}
+	var endpoints []config.Endpoint
+	for _, path := range []string{"/rejected", "/failed", "/ok"} {
+		endpoints = append(endpoints, config.Endpoint{Path: path, Protocol: "HTTP", ResponseFormat: true})
+	}
+	srv := newFakeProxy(t, fn, endpoints...)
+
+	tests := []struct {
+		path     string
+		status   int
+		expected Response
+	}{
+		// the response rendered by the gateway is not wrapped again
+		{path: "/rejected", status: http.StatusTooManyRequests, expected: Response{Code: 429, Reason: "TOO_MANY_REQUESTS", Message: "slow down"}},
+		{path: "/failed", status: http.StatusBadRequest, expected: Response{Code: 400, Reason: "INVALID_NAME", Message: "bad name", TraceID: "trace-1"}},
+		{path: "/ok", status: http.StatusOK, expected: Response{Code: 200, Reason: "SUCCESS", Message: "success!", TraceID: "trace-1"}},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(srv.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res Response
+		err = json.NewDecoder(resp.Body).Decode(&res)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.path, err)
+		}
+		if resp.StatusCode != tt.status || res.Code != tt.expected.Code || res.Reason != tt.expected.Reason ||
+			res.Message != tt.expected.Message || res.TraceID != tt.expected.TraceID {
+			t.Errorf("%s: expected %d %+v, got %d %+v", tt.path, tt.status, tt.expected, resp.StatusCode, res)
+		}
+		switch tt.path {
+		case "/rejected":
+			if res.Data != nil {
+				t.Errorf("%s: expected no data, got %v", tt.path, res.Data)
+			}
+		case "/failed":
+			if res.Metadata["field"] != "name" {
+				t.Errorf("%s: expected the metadata, got %v", tt.path, res.Metadata)
+			}
+		case "/ok":
+			if data, _ := res.Data.(map[string]any); data["name"] != "gateway" {
+				t.Errorf("%s: expected the upstream body as data, got %v", tt.path, res.Data)
+			}
+		}
+	}
+}
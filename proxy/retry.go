@@ -2,7 +2,12 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"math"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-kratos/feature"
@@ -13,6 +18,25 @@ import (
 
 var (
 	retryFeature = feature.MustRegister("gw:Retry", true)
+
+	errRetryDiscarded = errors.New("response discarded for a retry")
+)
+
+var _defaultIdempotentMethods = []string{
+	http.MethodGet,
+	http.MethodHead,
+	http.MethodOptions,
+	http.MethodTrace,
+	http.MethodPut,
+	http.MethodDelete,
+}
+
+const (
+	_defaultIdempotencyHeader   = "Idempotency-Key"
+	_defaultBackoffBaseInterval = 25 * time.Millisecond
+	_defaultBackoffMultiplier   = 2
+	// _defaultRetryAfterMaxInterval bounds the Retry-After wait of the endpoints without backoff.
+	_defaultRetryAfterMaxInterval = 10 * _defaultBackoffBaseInterval
 )
 
 type retryStrategy struct {
@@ -20,6 +44,18 @@ type retryStrategy struct {
 	timeout       time.Duration
 	perTryTimeout time.Duration
 	conditions    []condition.Condition
+	backoff       *backoffStrategy
+	hedgeDelay    time.Duration
+
+	idempotentMethods map[string]struct{}
+	idempotencyHeader string
+}
+
+type backoffStrategy struct {
+	baseInterval time.Duration
+	maxInterval  time.Duration
+	multiplier   float64
+	jitter       float64
 }
 
 func calcTimeout(endpoint *config.Endpoint) time.Duration {
@@ -67,9 +103,155 @@ func prepareRetryStrategy(e *config.Endpoint) (*retryStrategy, error) {
 		return nil, err
 	}
 	strategy.conditions = conditions
+	strategy.backoff = parseBackoff(e)
+	strategy.hedgeDelay = calcHedgeDelay(e)
+	strategy.idempotentMethods, strategy.idempotencyHeader = parseIdempotency(e)
 	return strategy, nil
 }
 
+func parseIdempotency(endpoint *config.Endpoint) (map[string]struct{}, string) {
+	methods := _defaultIdempotentMethods
+	header := _defaultIdempotencyHeader
+	if endpoint.Retry != nil {
+		if len(endpoint.Retry.IdempotentMethods) > 0 {
+			methods = endpoint.Retry.IdempotentMethods
+		}
+		if endpoint.Retry.IdempotencyHeader != "" {
+			header = endpoint.Retry.IdempotencyHeader
+		}
+	}
+	parsed := make(map[string]struct{}, len(methods))
+	for _, method := range methods {
+		parsed[strings.ToUpper(method)] = struct{}{}
+	}
+	return parsed, header
+}
+
+// idempotent reports whether the request may safely be sent more than once,
+// either by its method or because the client supplied an idempotency key.
+func (s *retryStrategy) idempotent(req *http.Request) bool {
+	if _, ok := s.idempotentMethods[req.Method]; ok {
+		return true
+	}
+	return req.Header.Get(s.idempotencyHeader) != ""
+}
+
+// retryOnError reports whether a failed attempt may be retried,
+// non-idempotent requests are only retried when they have never been sent.
+func (s *retryStrategy) retryOnError(req *http.Request, err error) bool {
+	if !condition.JudgeErrorConditons(s.conditions, err, true) {
+		return false
+	}
+	return s.idempotent(req) || condition.IsConnectError(err)
+}
+
+func calcHedgeDelay(endpoint *config.Endpoint) time.Duration {
+	if endpoint.Retry == nil || endpoint.Retry.Hedging == nil {
+		return 0
+	}
+	if endpoint.Retry.Count < 2 {
+		return 0
+	}
+	return endpoint.Retry.Hedging.Delay
+}
+
+func parseBackoff(endpoint *config.Endpoint) *backoffStrategy {
+	if endpoint.Retry == nil || endpoint.Retry.Backoff == nil {
+		return nil
+	}
+	in := endpoint.Retry.Backoff
+	backoff := &backoffStrategy{
+		baseInterval: in.BaseInterval,
+		maxInterval:  in.MaxInterval,
+		multiplier:   in.Multiplier,
+		jitter:       math.Min(math.Max(in.Jitter, 0), 1),
+	}
+	if backoff.baseInterval <= 0 {
+		backoff.baseInterval = _defaultBackoffBaseInterval
+	}
+	if backoff.maxInterval <= 0 {
+		backoff.maxInterval = 10 * backoff.baseInterval
+	}
+	if backoff.multiplier < 1 {
+		backoff.multiplier = _defaultBackoffMultiplier
+	}
+	return backoff
+}
+
+// delay returns the wait before the retry-th retry, a Retry-After header
+// of the previous response takes precedence over the computed interval.
+// The wait never exceeds the max interval.
+func (b *backoffStrategy) delay(retry int, resp *http.Response) time.Duration {
+	if d, ok := retryAfter(resp); ok {
+		return min(d, b.maxInterval)
+	}
+	d := float64(b.baseInterval) * math.Pow(b.multiplier, float64(retry-1))
+	if b.jitter > 0 {
+		d = d * (1 - b.jitter + 2*b.jitter*rand.Float64())
+	}
+	return time.Duration(math.Min(d, float64(b.maxInterval)))
+}
+
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	if resp == nil {
+		return 0, false
+	}
+	return parseRetryAfter(resp.Header.Get("Retry-After"))
+}
+
+func parseRetryAfter(in string) (time.Duration, bool) {
+	if in == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.ParseInt(in, 10, 64); err == nil {
+		if seconds < 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+	at, err := http.ParseTime(in)
+	if err != nil {
+		return 0, false
+	}
+	d := time.Until(at)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
+
+// backoffDelay returns the wait before the next attempt, false means the retry
+// must be abandoned because the wait would outlast the endpoint deadline.
+// Without backoff the retries are immediate, unless the previous response
+// asked for a wait with Retry-After.
+func (s *retryStrategy) backoffDelay(ctx context.Context, retry int, resp *http.Response) (time.Duration, bool) {
+	var d time.Duration
+	if s.backoff != nil {
+		d = s.backoff.delay(retry, resp)
+	} else if after, ok := retryAfter(resp); ok {
+		d = min(after, _defaultRetryAfterMaxInterval)
+	}
+	if d <= 0 {
+		return 0, true
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= d {
+		return 0, false
+	}
+	return d, true
+}
+
+func sleepContext(ctx context.Context, d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
 func parseRetryConditon(endpoint *config.Endpoint) ([]condition.Condition, error) {
 	if endpoint.Retry == nil {
 		return []condition.Condition{}, nil
@@ -0,0 +1,186 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/selector"
+
+	"github.com/limes-cloud/gateway/config"
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+func retryAfterResponse(value string) *http.Response {
+	resp := &http.Response{Header: http.Header{}}
+	if value != "" {
+		resp.Header.Set("Retry-After", value)
+	}
+	return resp
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		in       string
+		expected time.Duration
+		ok       bool
+	}{
+		{in: ""},
+		{in: "-1"},
+		{in: "soon"},
+		{in: "0", ok: true},
+		{in: "3", expected: 3 * time.Second, ok: true},
+		{in: time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat), ok: true},
+	}
+	for _, test := range tests {
+		d, ok := parseRetryAfter(test.in)
+		if ok != test.ok || d != test.expected {
+			t.Errorf("%q: expected %s %v, got %s %v", test.in, test.expected, test.ok, d, ok)
+		}
+	}
+	d, ok := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	if !ok || d <= 59*time.Minute || d > time.Hour {
+		t.Errorf("expected about an hour, got %s %v", d, ok)
+	}
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := &backoffStrategy{baseInterval: 100 * time.Millisecond, maxInterval: time.Second, multiplier: 2}
+	tests := []struct {
+		retry    int
+		resp     *http.Response
+		expected time.Duration
+	}{
+		{retry: 1, expected: 100 * time.Millisecond},
+		{retry: 3, expected: 400 * time.Millisecond},
+		{retry: 10, expected: time.Second},
+		{retry: 1, resp: retryAfterResponse("0"), expected: 0},
+		// Retry-After is clamped to the max interval
+		{retry: 1, resp: retryAfterResponse("30"), expected: time.Second},
+		{retry: 3, resp: retryAfterResponse("invalid"), expected: 400 * time.Millisecond},
+	}
+	for _, test := range tests {
+		if d := b.delay(test.retry, test.resp); d != test.expected {
+			t.Errorf("retry %d: expected %s, got %s", test.retry, test.expected, d)
+		}
+	}
+
+	b.jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if d := b.delay(1, nil); d < 50*time.Millisecond || d > 150*time.Millisecond {
+			t.Fatalf("expected the jitter within 50%%, got %s", d)
+		}
+	}
+}
+
+func TestBackoffDelayWithoutBackoff(t *testing.T) {
+	s := &retryStrategy{}
+	if d, ok := s.backoffDelay(context.Background(), 1, nil); !ok || d != 0 {
+		t.Fatalf("expected an immediate retry, got %s %v", d, ok)
+	}
+	// Retry-After is honoured without backoff, bounded by the default max interval
+	if d, ok := s.backoffDelay(context.Background(), 1, retryAfterResponse("5")); !ok || d != _defaultRetryAfterMaxInterval {
+		t.Fatalf("expected the default max interval, got %s %v", d, ok)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	if _, ok := s.backoffDelay(ctx, 1, retryAfterResponse("1")); ok {
+		t.Fatal("expected the retry to be abandoned past the deadline")
+	}
+}
+
+func TestRetryDiscardedResponseDone(t *testing.T) {
+	var (
+		lock  sync.Mutex
+		dones []error
+		calls int
+	)
+	fn := func(req *http.Request) (*http.Response, error) {
+		reqOpt, _ := middleware.FromRequestContext(req.Context())
+		reqOpt.DoneFunc = func(_ context.Context, di selector.DoneInfo) {
+			lock.Lock()
+			defer lock.Unlock()
+			dones = append(dones, di.Err)
+		}
+		lock.Lock()
+		calls++
+		first := calls == 1
+		lock.Unlock()
+		w := httptest.NewRecorder()
+		if first {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		} else {
+			_, _ = io.WriteString(w, "ok")
+		}
+		return w.Result(), nil
+	}
+	srv := newFakeProxy(t, fn, config.Endpoint{Path: "/retry", Protocol: "HTTP", Retry: &config.Retry{
+		Count:      2,
+		Conditions: []config.Condition{{StatusCode: "503"}},
+	}})
+	resp, err := http.Get(srv.URL + "/retry")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || strings.TrimSpace(string(b)) != "ok" {
+		t.Fatalf("expected the retry to succeed, got %d %q", resp.StatusCode, b)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(dones) != 2 || dones[0] != errRetryDiscarded || dones[1] != nil {
+		t.Fatalf("expected the discarded and the final attempts to be done, got %v", dones)
+	}
+}
+
+func TestRetryOnTransportError(t *testing.T) {
+	refused := &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}
+	reset := &net.OpError{Op: "read", Net: "tcp", Err: os.NewSyscallError("read", syscall.ECONNRESET)}
+	tests := []struct {
+		name     string
+		method   string
+		key      string
+		err      error
+		expected int
+	}{
+		{name: "post reset", method: http.MethodPost, err: reset, expected: 1},
+		{name: "post refused", method: http.MethodPost, err: refused, expected: 3},
+		{name: "post reset with key", method: http.MethodPost, key: "k1", err: reset, expected: 3},
+		{name: "get reset", method: http.MethodGet, err: reset, expected: 3},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var calls atomic.Int32
+			srv := newFakeProxy(t, func(*http.Request) (*http.Response, error) {
+				calls.Add(1)
+				return nil, tt.err
+			}, config.Endpoint{Path: "/retry", Protocol: "HTTP", Retry: &config.Retry{
+				Count:      3,
+				Conditions: []config.Condition{{TransportError: `["reset","connect_refused"]`}},
+			}})
+			req, _ := http.NewRequest(tt.method, srv.URL+"/retry", strings.NewReader("body"))
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := int(calls.Load()); got != tt.expected {
+				t.Fatalf("expected %d attempts, got %d", tt.expected, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/go-kratos/kratos/v2/log"
+	"github.com/go-kratos/kratos/v2/selector"
+	"golang.org/x/net/http/httpguts"
+
+	"github.com/limes-cloud/gateway/middleware"
+)
+
+// upgradeType returns the protocol requested by a `Connection: Upgrade` header.
+func upgradeType(h http.Header) string {
+	if !httpguts.HeaderValuesContainsToken(h["Connection"], "Upgrade") {
+		return ""
+	}
+	return h.Get("Upgrade")
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// serveUpgrade proxies a protocol switch request (WebSocket, h2c ...),
+// see https://github.com/golang/go/blob/master/src/net/http/httputil/reverseproxy.go
+// The handshake goes through the middlewares and the selector like any other request,
+// once the upstream accepts the switch the downstream connection is hijacked and
+// bytes are pumped both ways until either side closes.
+func serveUpgrade(ctx context.Context, w http.ResponseWriter, req *http.Request, tripper http.RoundTripper, reqOpts *middleware.RequestOptions, labels middleware.MetricsLabels) {
+	reqUpType := upgradeType(req.Header)
+	reqOpts.LastAttempt = true
+	resp, err := tripper.RoundTrip(req.Clone(ctx))
+	if err != nil {
+		writeError(w, req, reqOpts.Endpoint, err, labels)
+		return
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// the upstream refused to switch protocols, relay its response
+		defer resp.Body.Close()
+		copyHeader(w.Header(), resp.Header)
+		w.WriteHeader(resp.StatusCode)
+		sent, err := io.Copy(w, resp.Body)
+		sentBytesAdd(labels, sent)
+		reqOpts.DoneFunc(ctx, selector.DoneInfo{Err: err})
+		requestsTotalIncr(labels, resp.StatusCode)
+		return
+	}
+
+	resUpType := upgradeType(resp.Header)
+	if !strings.EqualFold(reqUpType, resUpType) {
+		resp.Body.Close()
+		writeError(w, req, reqOpts.Endpoint, fmt.Errorf("backend tried to switch protocol %q when %q was requested", resUpType, reqUpType), labels)
+		return
+	}
+	backConn, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		writeError(w, req, reqOpts.Endpoint, errors.New("101 switching protocols response with non-writable body"), labels)
+		return
+	}
+	defer backConn.Close()
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		writeError(w, req, reqOpts.Endpoint, fmt.Errorf("can't switch protocols using non-Hijacker ResponseWriter type %T: %w", w, err), labels)
+		return
+	}
+	defer conn.Close()
+
+	// close the upstream connection once the client request is gone
+	backConnCloseCh := make(chan struct{})
+	go func() {
+		select {
+		case <-req.Context().Done():
+		case <-backConnCloseCh:
+		}
+		backConn.Close()
+	}()
+	defer close(backConnCloseCh)
+
+	copyHeader(w.Header(), resp.Header)
+	resp.Header = w.Header()
+	resp.Body = nil
+	if err := resp.Write(brw); err != nil {
+		reqOpts.DoneFunc(ctx, selector.DoneInfo{Err: err})
+		log.Errorf("Failed to write switching protocols response: %s: %+v", req.URL.String(), err)
+		return
+	}
+	if err := brw.Flush(); err != nil {
+		reqOpts.DoneFunc(ctx, selector.DoneInfo{Err: err})
+		log.Errorf("Failed to flush switching protocols response: %s: %+v", req.URL.String(), err)
+		return
+	}
+	requestsTotalIncr(labels, resp.StatusCode)
+
+	type copyResult struct {
+		n        int64
+		err      error
+		upstream bool
+	}
+	results := make(chan copyResult, 2)
+	go func() {
+		n, err := io.Copy(conn, backConn)
+		results <- copyResult{n: n, err: err, upstream: true}
+	}()
+	go func() {
+		n, err := io.Copy(backConn, brw.Reader)
+		results <- copyResult{n: n, err: err}
+	}()
+
+	first := <-results
+	// unblock the other direction
+	conn.Close()
+	backConn.Close()
+	second := <-results
+	for _, r := range []copyResult{first, second} {
+		if r.upstream {
+			sentBytesAdd(labels, r.n)
+		} else {
+			receivedBytesAdd(labels, r.n)
+		}
+	}
+	reqOpts.DoneFunc(ctx, selector.DoneInfo{})
+}
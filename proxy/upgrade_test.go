@@ -0,0 +1,109 @@
+package proxy
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+// newEchoUpstream switches to the echo protocol and echoes the lines it reads,
+// it closes the connection on "bye" and reports when the connection is gone.
+func newEchoUpstream(t *testing.T) (*httptest.Server, chan struct{}) {
+	closed := make(chan struct{}, 10)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if upgradeType(r.Header) != "echo" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer func() {
+			conn.Close()
+			closed <- struct{}{}
+		}()
+		_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = brw.Flush()
+		for {
+			line, err := brw.ReadString('\n')
+			if err != nil || line == "bye\n" {
+				return
+			}
+			_, _ = brw.WriteString(line)
+			_ = brw.Flush()
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, closed
+}
+
+func dialUpgrade(t *testing.T, srv *httptest.Server, upgrade string) (net.Conn, *bufio.Reader, *http.Response) {
+	t.Helper()
+	conn, err := net.Dial("tcp", strings.TrimPrefix(srv.URL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_, _ = io.WriteString(conn, "GET /ws HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: "+upgrade+"\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn, br, resp
+}
+
+func waitClosed(t *testing.T, closed chan struct{}) {
+	t.Helper()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("expected the upstream connection to be closed")
+	}
+}
+
+func TestServeUpgrade(t *testing.T) {
+	upstream, closed := newEchoUpstream(t)
+	srv := newTestProxy(t, config.Endpoint{Path: "/ws", Protocol: "HTTP", Backends: backendOf(upstream)})
+
+	// the client closes first
+	conn, br, resp := dialUpgrade(t, srv, "echo")
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Upgrade") != "echo" {
+		t.Fatalf("expected 101 switching to echo, got %d %v", resp.StatusCode, resp.Header)
+	}
+	for _, msg := range []string{"hello\n", "world\n"} {
+		_, _ = io.WriteString(conn, msg)
+		line, err := br.ReadString('\n')
+		if err != nil || line != msg {
+			t.Fatalf("expected echo %q, got %q %v", msg, line, err)
+		}
+	}
+	conn.Close()
+	waitClosed(t, closed)
+
+	// the upstream closes first
+	conn, br, resp = dialUpgrade(t, srv, "echo")
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("expected 101, got %d", resp.StatusCode)
+	}
+	_, _ = io.WriteString(conn, "bye\n")
+	waitClosed(t, closed)
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if b, err := io.ReadAll(br); err != nil || len(b) != 0 {
+		t.Fatalf("expected the client connection to be closed, got %q %v", b, err)
+	}
+
+	// a refused switch is relayed as a regular response
+	if _, _, resp = dialUpgrade(t, srv, "other"); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the upstream refusal, got %d", resp.StatusCode)
+	}
+}
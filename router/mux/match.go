@@ -0,0 +1,174 @@
+package mux
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+// withMatch adds the header, query and cookie rules to the route,
+// gorilla matchers are used where they can express the rule.
+func withMatch(route *mux.Route, match *config.Match) (*mux.Route, error) {
+	for _, rule := range match.Headers {
+		if err := validateRule("header", rule); err != nil {
+			return nil, err
+		}
+		switch {
+		case rule.Absent:
+			name := rule.Name
+			route = route.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+				return len(req.Header.Values(name)) == 0
+			})
+		case rule.Regex != "":
+			route = route.HeadersRegexp(rule.Name, rule.Regex)
+		case rule.Prefix != "":
+			route = route.HeadersRegexp(rule.Name, "^"+regexp.QuoteMeta(rule.Prefix))
+		default:
+			// an empty value matches any request carrying the header
+			route = route.Headers(rule.Name, rule.Value)
+		}
+	}
+	for _, rule := range match.Queries {
+		if err := validateRule("query", rule); err != nil {
+			return nil, err
+		}
+		switch {
+		case rule.Absent, rule.Regex != "", rule.Prefix != "":
+			matcher, err := newValueMatcher(rule)
+			if err != nil {
+				return nil, err
+			}
+			name := rule.Name
+			route = route.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+				values, ok := req.URL.Query()[name]
+				return matcher(values, ok)
+			})
+		default:
+			route = route.Queries(rule.Name, rule.Value)
+		}
+	}
+	for _, rule := range match.Cookies {
+		if err := validateRule("cookie", rule); err != nil {
+			return nil, err
+		}
+		matcher, err := newValueMatcher(rule)
+		if err != nil {
+			return nil, err
+		}
+		name := rule.Name
+		route = route.MatcherFunc(func(req *http.Request, _ *mux.RouteMatch) bool {
+			cookie, err := req.Cookie(name)
+			if err != nil {
+				return matcher(nil, false)
+			}
+			return matcher([]string{cookie.Value}, true)
+		})
+	}
+	return route, route.GetError()
+}
+
+func validateRule(kind string, rule config.MatchRule) error {
+	if rule.Name == "" {
+		return fmt.Errorf("empty %s name in match rule", kind)
+	}
+	set := 0
+	for _, ok := range []bool{rule.Value != "", rule.Prefix != "", rule.Regex != "", rule.Present, rule.Absent} {
+		if ok {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("ambiguous %s match rule: %s", kind, rule.Name)
+	}
+	return nil
+}
+
+// newValueMatcher returns a matcher that is called with the values
+// of the rule's name and whether it was present in the request.
+func newValueMatcher(rule config.MatchRule) (func(values []string, ok bool) bool, error) {
+	var match func(string) bool
+	switch {
+	case rule.Absent:
+		return func(_ []string, ok bool) bool { return !ok }, nil
+	case rule.Regex != "":
+		re, err := regexp.Compile(rule.Regex)
+		if err != nil {
+			return nil, err
+		}
+		match = re.MatchString
+	case rule.Prefix != "":
+		match = func(v string) bool { return strings.HasPrefix(v, rule.Prefix) }
+	case rule.Value != "":
+		match = func(v string) bool { return v == rule.Value }
+	default:
+		return func(_ []string, ok bool) bool { return ok }, nil
+	}
+	return func(values []string, ok bool) bool {
+		for _, v := range values {
+			if match(v) {
+				return true
+			}
+		}
+		return false
+	}, nil
+}
+
+// matchRules returns the number of match rules, used to rank routes.
+func matchRules(match *config.Match) int {
+	if match == nil {
+		return 0
+	}
+	return len(match.Headers) + len(match.Queries) + len(match.Cookies)
+}
+
+// routeKey identifies the endpoints competing for the same requests.
+type routeKey struct {
+	path   string
+	method string
+	host   string
+}
+
+func newRouteKey(e *config.Endpoint) routeKey {
+	method := strings.ToUpper(e.Method)
+	if method == "*" {
+		method = ""
+	}
+	return routeKey{path: e.Path, method: method, host: strings.ToLower(e.Host)}
+}
+
+// SortEndpoints orders the endpoints sharing the same path, method and host
+// so that more specific routes win: higher priority first, then routes with
+// more match rules. Other endpoints keep their configured order, as do equal routes.
+func SortEndpoints(endpoints []config.Endpoint) []config.Endpoint {
+	out := append([]config.Endpoint(nil), endpoints...)
+	slots := make(map[routeKey][]int)
+	for i := range out {
+		key := newRouteKey(&out[i])
+		slots[key] = append(slots[key], i)
+	}
+	for _, indexes := range slots {
+		if len(indexes) < 2 {
+			continue
+		}
+		group := make([]config.Endpoint, 0, len(indexes))
+		for _, i := range indexes {
+			group = append(group, out[i])
+		}
+		sort.SliceStable(group, func(i, j int) bool {
+			if group[i].Priority != group[j].Priority {
+				return group[i].Priority > group[j].Priority
+			}
+			return matchRules(group[i].Match) > matchRules(group[j].Match)
+		})
+		for k, i := range indexes {
+			out[i] = group[k]
+		}
+	}
+	return out
+}
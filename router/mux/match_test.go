@@ -0,0 +1,118 @@
+package mux
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/limes-cloud/gateway/config"
+)
+
+func newTestRouter(t *testing.T, endpoints ...config.Endpoint) http.Handler {
+	t.Helper()
+	r := NewRouter(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}), http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	for _, e := range SortEndpoints(endpoints) {
+		name := e.Metadata["name"]
+		handler := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			_, _ = io.WriteString(w, name)
+		})
+		if err := r.Handle(e.Path, e.Method, e.Host, e.Match, handler, io.NopCloser(nil)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return r
+}
+
+func endpoint(name, method, path string, priority int, match *config.Match) config.Endpoint {
+	return config.Endpoint{Path: path, Method: method, Priority: priority, Match: match, Metadata: map[string]string{"name": name}}
+}
+
+func serve(h http.Handler, req *http.Request) (int, string) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code, w.Body.String()
+}
+
+func TestMatchRules(t *testing.T) {
+	h := newTestRouter(t,
+		endpoint("default", "GET", "/api", 0, nil),
+		endpoint("header", "GET", "/api", 0, &config.Match{Headers: []config.MatchRule{{Name: "X-Version", Value: "v2"}}}),
+		endpoint("prefix", "GET", "/api", 0, &config.Match{Headers: []config.MatchRule{{Name: "X-Client", Prefix: "mobile-"}}}),
+		endpoint("query", "GET", "/api", 0, &config.Match{Queries: []config.MatchRule{{Name: "beta", Regex: "^(1|true)$"}}}),
+		endpoint("cookie", "GET", "/api", 0, &config.Match{Cookies: []config.MatchRule{{Name: "canary", Value: "on"}}}),
+		endpoint("absent", "GET", "/api", 0, &config.Match{Queries: []config.MatchRule{{Name: "legacy", Absent: true}, {Name: "v", Value: "3"}}}),
+		endpoint("header only", "POST", "/form", 0, &config.Match{Headers: []config.MatchRule{{Name: "X-Token", Present: true}}}),
+	)
+	tests := []struct {
+		name     string
+		method   string
+		target   string
+		header   http.Header
+		status   int
+		expected string
+	}{
+		{name: "no rule", method: "GET", target: "/api", status: 200, expected: "default"},
+		{name: "header", method: "GET", target: "/api", header: http.Header{"X-Version": {"v2"}}, status: 200, expected: "header"},
+		{name: "header value", method: "GET", target: "/api", header: http.Header{"X-Version": {"v1"}}, status: 200, expected: "default"},
+		{name: "header prefix", method: "GET", target: "/api", header: http.Header{"X-Client": {"mobile-ios"}}, status: 200, expected: "prefix"},
+		{name: "query regex", method: "GET", target: "/api?beta=true", status: 200, expected: "query"},
+		{name: "query regex mismatch", method: "GET", target: "/api?beta=yes", status: 200, expected: "default"},
+		{name: "cookie", method: "GET", target: "/api", header: http.Header{"Cookie": {"canary=on"}}, status: 200, expected: "cookie"},
+		{name: "query absent", method: "GET", target: "/api?v=3", status: 200, expected: "absent"},
+		{name: "query present", method: "GET", target: "/api?v=3&legacy=1", status: 200, expected: "default"},
+		{name: "method", method: "PUT", target: "/api", status: 405},
+		{name: "header present", method: "POST", target: "/form", header: http.Header{"X-Token": {"t"}}, status: 200, expected: "header only"},
+		// a route failing its match rules is not found, whatever the method
+		{name: "header missing", method: "POST", target: "/form", status: 404},
+		{name: "header missing and method", method: "GET", target: "/form", status: 404},
+		{name: "header and method", method: "GET", target: "/form", header: http.Header{"X-Token": {"t"}}, status: 405},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.target, nil)
+		for k, v := range tt.header {
+			req.Header[k] = v
+		}
+		status, body := serve(h, req)
+		if status != tt.status || (tt.expected != "" && body != tt.expected) {
+			t.Errorf("%s: expected %d %q, got %d %q", tt.name, tt.status, tt.expected, status, body)
+		}
+	}
+}
+
+func TestSortEndpoints(t *testing.T) {
+	header := &config.Match{Headers: []config.MatchRule{{Name: "X-Version", Value: "v2"}}}
+	endpoints := []config.Endpoint{
+		endpoint("prefix", "GET", "/api/*", 0, nil),
+		endpoint("get", "GET", "/api/users", 0, nil),
+		endpoint("other method", "POST", "/api/users", 10, nil),
+		endpoint("header", "GET", "/api/users", 0, header),
+		endpoint("priority", "get", "/api/users", 5, nil),
+		endpoint("other host", "GET", "/api/users", 20, nil),
+	}
+	endpoints[5].Host = "example.com"
+	var got []string
+	for _, e := range SortEndpoints(endpoints) {
+		got = append(got, e.Metadata["name"])
+	}
+	// only the routes of the same path, method and host swap their slots
+	expected := []string{"prefix", "priority", "other method", "header", "get", "other host"}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected %v, got %v", expected, got)
+		}
+	}
+	if endpoints[1].Metadata["name"] != "get" {
+		t.Fatal("expected the configured endpoints to be left untouched")
+	}
+
+	// the catch-all prefix route configured first keeps winning
+	h := newTestRouter(t, endpoints...)
+	if _, body := serve(h, httptest.NewRequest("GET", "/api/users", nil)); body != "prefix" {
+		t.Fatalf("expected the first configured route, got %q", body)
+	}
+}
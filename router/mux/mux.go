@@ -14,6 +14,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/limes-cloud/gateway/config"
 	"github.com/limes-cloud/gateway/router"
 )
 
@@ -74,7 +75,7 @@ func (r *muxRouter) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	r.Router.ServeHTTP(w, req)
 }
 
-func (r *muxRouter) Handle(pattern, method, host string, handler http.Handler, closer io.Closer) error {
+func (r *muxRouter) Handle(pattern, method, host string, match *config.Match, handler http.Handler, closer io.Closer) error {
 	next := r.Router.NewRoute().Handler(handler)
 	if host != "" {
 		next = next.Host(host)
@@ -91,6 +92,12 @@ func (r *muxRouter) Handle(pattern, method, host string, handler http.Handler, c
 	if method != "" && method != "*" {
 		next = next.Methods(method, http.MethodOptions)
 	}
+	if match != nil {
+		var err error
+		if next, err = withMatch(next, match); err != nil {
+			return err
+		}
+	}
 	if err := next.GetError(); err != nil {
 		return err
 	}
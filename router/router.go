@@ -4,11 +4,13 @@ import (
 	"context"
 	"io"
 	"net/http"
+
+	"github.com/limes-cloud/gateway/config"
 )
 
 // Router is a gateway router.
 type Router interface {
 	http.Handler
-	Handle(pattern, method, host string, handler http.Handler, closer io.Closer) error
+	Handle(pattern, method, host string, match *config.Match, handler http.Handler, closer io.Closer) error
 	SyncClose(ctx context.Context) error
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"math"
+	"net"
 	"net/http"
 	"os"
 	"time"
@@ -11,6 +12,8 @@ import (
 	"github.com/go-kratos/kratos/v2/log"
 	"golang.org/x/net/http2"
 	"golang.org/x/net/http2/h2c"
+
+	"github.com/limes-cloud/gateway/utils/clientip"
 )
 
 var (
@@ -47,11 +50,25 @@ func init() {
 // ProxyServer is a proxy server.
 type ProxyServer struct {
 	*http.Server
+	proxyProtocol bool
+	trusted       clientip.Prefixes
+}
+
+// Option is a proxy server option.
+type Option func(*ProxyServer)
+
+// ProxyProtocol reads the PROXY protocol header sent by the trusted proxies,
+// the header is required from them and ignored from the other sources.
+func ProxyProtocol(trusted clientip.Prefixes) Option {
+	return func(s *ProxyServer) {
+		s.proxyProtocol = true
+		s.trusted = trusted
+	}
 }
 
 // NewProxy new a gateway server.
-func NewProxy(handler http.Handler, addr string) *ProxyServer {
-	return &ProxyServer{
+func NewProxy(handler http.Handler, addr string, opts ...Option) *ProxyServer {
+	s := &ProxyServer{
 		Server: &http.Server{
 			Addr: addr,
 			Handler: h2c.NewHandler(handler, &http2.Server{
@@ -64,12 +81,27 @@ func NewProxy(handler http.Handler, addr string) *ProxyServer {
 			IdleTimeout: idleTimeout,
 		},
 	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
 }
 
 // Start the server.
 func (s *ProxyServer) Start(ctx context.Context) error {
 	log.Infof("proxy listening on %s", s.Addr)
-	err := s.ListenAndServe()
+	addr := s.Addr
+	if addr == "" {
+		addr = ":http"
+	}
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	if s.proxyProtocol {
+		ln = newProxyListener(ln, s.trusted, readHeaderTimeout)
+	}
+	err = s.Serve(ln)
 	if errors.Is(err, http.ErrServerClosed) {
 		return nil
 	}
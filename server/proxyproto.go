@@ -0,0 +1,199 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/limes-cloud/gateway/utils/clientip"
+)
+
+// The PROXY protocol lets a load balancer pass the address of the client,
+// see https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+var (
+	proxyV1Prefix    = []byte("PROXY ")
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	errInvalidProxyHeader = errors.New("invalid proxy protocol header")
+	errMissingProxyHeader = errors.New("missing proxy protocol header")
+)
+
+const proxyV1MaxLength = 107
+
+// proxyListener reads the PROXY protocol header of the connections accepted
+// from the trusted proxies, which must send one. The connections of the other
+// sources are served as is.
+type proxyListener struct {
+	net.Listener
+	trusted clientip.Prefixes
+	timeout time.Duration
+}
+
+func newProxyListener(ln net.Listener, trusted clientip.Prefixes, timeout time.Duration) net.Listener {
+	return &proxyListener{Listener: ln, trusted: trusted, timeout: timeout}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !l.trusted.Contains(addr.AddrPort().Addr()) {
+		return conn, nil
+	}
+	// the header is read by the connection goroutine, not to block the accept loop
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn), timeout: l.timeout}, nil
+}
+
+type proxyConn struct {
+	net.Conn
+	reader  *bufio.Reader
+	timeout time.Duration
+	once    sync.Once
+	remote  net.Addr
+	err     error
+}
+
+func (c *proxyConn) init() {
+	c.once.Do(func() {
+		if c.timeout > 0 {
+			_ = c.Conn.SetReadDeadline(time.Now().Add(c.timeout))
+			defer c.Conn.SetReadDeadline(time.Time{})
+		}
+		c.remote, c.err = readProxyHeader(c.reader)
+		if c.err != nil {
+			// nothing is answered to a connection without a valid header
+			_ = c.Conn.Close()
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.init()
+	if c.err != nil {
+		return 0, c.err
+	}
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.init()
+	if c.remote != nil {
+		return c.remote
+	}
+	return c.Conn.RemoteAddr()
+}
+
+// readProxyHeader reads the header the connection must start with, it returns
+// a nil address for the local or unknown sources.
+func readProxyHeader(r *bufio.Reader) (net.Addr, error) {
+	first, err := r.Peek(1)
+	if err != nil {
+		return nil, err
+	}
+	switch first[0] {
+	case proxyV1Prefix[0]:
+		prefix, err := r.Peek(len(proxyV1Prefix))
+		if err != nil || !bytes.Equal(prefix, proxyV1Prefix) {
+			// another request starting with P
+			return nil, errMissingProxyHeader
+		}
+		return readProxyV1(r)
+	case proxyV2Signature[0]:
+		signature, err := r.Peek(len(proxyV2Signature))
+		if err != nil || !bytes.Equal(signature, proxyV2Signature) {
+			return nil, errMissingProxyHeader
+		}
+		return readProxyV2(r)
+	}
+	return nil, errMissingProxyHeader
+}
+
+func readProxyV1(r *bufio.Reader) (net.Addr, error) {
+	var line []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+		if len(line) >= proxyV1MaxLength {
+			return nil, errInvalidProxyHeader
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return nil, errInvalidProxyHeader
+	}
+	fields := strings.Fields(string(line[:len(line)-2]))
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, errInvalidProxyHeader
+	}
+	addr, err := netip.ParseAddr(fields[2])
+	if err != nil {
+		return nil, errInvalidProxyHeader
+	}
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, errInvalidProxyHeader
+	}
+	return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(port))), nil
+}
+
+func readProxyV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	version, command := header[12]>>4, header[12]&0x0f
+	family, transport := header[13]>>4, header[13]&0x0f
+	payload := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+	if version != 2 {
+		return nil, fmt.Errorf("%w: version %d", errInvalidProxyHeader, version)
+	}
+	switch command {
+	case 0x0:
+		// LOCAL, e.g. health checks of the proxy itself
+		return nil, nil
+	case 0x1:
+	default:
+		return nil, fmt.Errorf("%w: command %d", errInvalidProxyHeader, command)
+	}
+	if transport != 0x1 {
+		// only stream sources are meaningful for http
+		return nil, nil
+	}
+	switch family {
+	case 0x1:
+		if len(payload) < 12 {
+			return nil, errInvalidProxyHeader
+		}
+		addr := netip.AddrFrom4([4]byte(payload[0:4]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[8:10]))), nil
+	case 0x2:
+		if len(payload) < 36 {
+			return nil, errInvalidProxyHeader
+		}
+		addr := netip.AddrFrom16([16]byte(payload[0:16]))
+		return net.TCPAddrFromAddrPort(netip.AddrPortFrom(addr, binary.BigEndian.Uint16(payload[32:34]))), nil
+	}
+	return nil, nil
+}
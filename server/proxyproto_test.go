@@ -0,0 +1,126 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/limes-cloud/gateway/utils/clientip"
+)
+
+func proxyV2Header(src net.IP, port uint16) []byte {
+	var b bytes.Buffer
+	b.Write(proxyV2Signature)
+	b.Write([]byte{0x21, 0x11, 0, 12})
+	b.Write(src.To4())
+	b.Write(net.IPv4(10, 0, 0, 1).To4())
+	_ = binary.Write(&b, binary.BigEndian, port)
+	_ = binary.Write(&b, binary.BigEndian, uint16(80))
+	return b.Bytes()
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	tests := []struct {
+		input  string
+		remote string
+		rest   string
+		err    bool
+	}{
+		{input: "PROXY TCP4 1.2.3.4 10.0.0.1 5678 80\r\nGET /", remote: "1.2.3.4:5678", rest: "GET /"},
+		{input: "PROXY TCP6 2001:db8::1 ::1 5678 80\r\nGET /", remote: "[2001:db8::1]:5678", rest: "GET /"},
+		{input: "PROXY UNKNOWN\r\nGET /", rest: "GET /"},
+		{input: string(proxyV2Header(net.IPv4(1, 2, 3, 4), 5678)) + "GET /", remote: "1.2.3.4:5678", rest: "GET /"},
+		{input: "POST / HTTP/1.1\r\n", err: true},
+		{input: "PRI * HTTP/2.0\r\n", err: true},
+		{input: "PROXY TCP4 nope 10.0.0.1 5678 80\r\n", err: true},
+		{input: "PROXY TCP4 1.2.3.4 10.0.0.1 5678 80" + strings.Repeat(" ", 100) + "\r\n", err: true},
+	}
+	for _, test := range tests {
+		r := bufio.NewReader(strings.NewReader(test.input))
+		addr, err := readProxyHeader(r)
+		if test.err {
+			if err == nil {
+				t.Errorf("%q: expected error", test.input)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %v", test.input, err)
+			continue
+		}
+		remote := ""
+		if addr != nil {
+			remote = addr.String()
+		}
+		rest, _ := io.ReadAll(r)
+		if remote != test.remote || string(rest) != test.rest {
+			t.Errorf("%q: got remote %q rest %q", test.input, remote, rest)
+		}
+	}
+}
+
+func serveProxyListener(t *testing.T, trusted ...string) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefixes, _ := clientip.ParsePrefixes(trusted)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.RemoteAddr)
+	})}
+	go srv.Serve(newProxyListener(ln, prefixes, time.Second))
+	t.Cleanup(func() { srv.Close() })
+	return ln.Addr().String()
+}
+
+// send writes the raw request and returns the remote address seen by the server.
+func send(t *testing.T, addr, request string) (string, error) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_, _ = io.WriteString(conn, request)
+	resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		return "", err
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return string(body), nil
+}
+
+func TestProxyListener(t *testing.T) {
+	const request = "GET / HTTP/1.1\r\nHost: a\r\nConnection: close\r\n\r\n"
+	addr := serveProxyListener(t, "127.0.0.1")
+	remote, err := send(t, addr, "PROXY TCP4 1.2.3.4 10.0.0.1 5678 80\r\n"+request)
+	if err != nil || remote != "1.2.3.4:5678" {
+		t.Fatalf("expected the proxied address, got %s %v", remote, err)
+	}
+	// a trusted proxy must send the header
+	if remote, err = send(t, addr, request); err == nil {
+		t.Fatalf("expected the connection without header to be closed, got %s", remote)
+	}
+
+	// the other sources are served as is, their header is not read
+	addr = serveProxyListener(t, "10.0.0.0/8")
+	if remote, err = send(t, addr, request); err != nil || !strings.HasPrefix(remote, "127.0.0.1:") {
+		t.Fatalf("expected the address of the connection, got %s %v", remote, err)
+	}
+	if remote, err = send(t, addr, "PROXY TCP4 1.2.3.4 10.0.0.1 5678 80\r\n"+request); err == nil && remote == "1.2.3.4:5678" {
+		t.Fatal("expected the header of an untrusted source to be ignored")
+	}
+
+	// nothing is trusted without trusted proxies
+	addr = serveProxyListener(t)
+	if remote, err = send(t, addr, request); err != nil || !strings.HasPrefix(remote, "127.0.0.1:") {
+		t.Fatalf("expected the address of the connection, got %s %v", remote, err)
+	}
+}
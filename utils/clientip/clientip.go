@@ -0,0 +1,122 @@
+// Package clientip resolves the address of the client behind trusted proxies.
+//
+// The forwarding headers are only read when the request comes from a trusted
+// proxy, X-Forwarded-For is walked from the right and the first address that is
+// not a trusted proxy is the client, so that addresses prepended by the client
+// itself are never taken.
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// Prefixes is a list of networks, single addresses are taken as /32 or /128 networks.
+type Prefixes []netip.Prefix
+
+// ParsePrefixes parses a list of CIDRs and addresses.
+func ParsePrefixes(list []string) (Prefixes, error) {
+	prefixes := make(Prefixes, 0, len(list))
+	for _, item := range list {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if strings.Contains(item, "/") {
+			prefix, err := netip.ParsePrefix(item)
+			if err != nil {
+				return nil, fmt.Errorf("invalid cidr %q: %w", item, err)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid ip %q: %w", item, err)
+		}
+		addr = addr.Unmap()
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// Contains reports whether the address is in any of the networks.
+func (p Prefixes) Contains(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	for _, prefix := range p {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// ContainsString is Contains for a textual address, invalid addresses are never contained.
+func (p Prefixes) ContainsString(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return p.Contains(addr)
+}
+
+// Resolver resolves the client address of requests.
+type Resolver struct {
+	trusted Prefixes
+}
+
+// NewResolver returns a resolver trusting the forwarding headers set by the given proxies.
+func NewResolver(trusted Prefixes) *Resolver {
+	return &Resolver{trusted: trusted}
+}
+
+// RemoteIP returns the address of the peer of the request.
+func RemoteIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
+
+func parseAddr(in string) (netip.Addr, bool) {
+	in = strings.TrimSpace(in)
+	if addr, err := netip.ParseAddr(in); err == nil {
+		return addr.Unmap(), true
+	}
+	// some proxies add the port
+	if addrPort, err := netip.ParseAddrPort(in); err == nil {
+		return addrPort.Addr().Unmap(), true
+	}
+	return netip.Addr{}, false
+}
+
+// Resolve returns the client address of the request.
+func (r *Resolver) Resolve(req *http.Request) string {
+	remote := RemoteIP(req)
+	addr, ok := parseAddr(remote)
+	if !ok || !r.trusted.Contains(addr) {
+		return remote
+	}
+	if values := req.Header.Values("X-Forwarded-For"); len(values) > 0 {
+		hops := strings.Split(strings.Join(values, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop, ok := parseAddr(hops[i])
+			if !ok {
+				// the chain can not be trusted past a malformed hop
+				break
+			}
+			if !r.trusted.Contains(hop) || i == 0 {
+				return hop.String()
+			}
+		}
+		return remote
+	}
+	if hop, ok := parseAddr(req.Header.Get("X-Real-IP")); ok {
+		return hop.String()
+	}
+	return remote
+}
@@ -0,0 +1,64 @@
+package clientip
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestResolve(t *testing.T) {
+	trusted, err := ParsePrefixes([]string{"10.0.0.0/8", "192.168.1.1", "fd00::/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := NewResolver(trusted)
+	tests := []struct {
+		remote string
+		xff    []string
+		realIP string
+		want   string
+	}{
+		// headers of untrusted peers are ignored
+		{remote: "1.1.1.1:1000", xff: []string{"2.2.2.2"}, want: "1.1.1.1"},
+		{remote: "1.1.1.1:1000", realIP: "2.2.2.2", want: "1.1.1.1"},
+		{remote: "10.0.0.1:1000", want: "10.0.0.1"},
+		{remote: "10.0.0.1:1000", xff: []string{"2.2.2.2"}, want: "2.2.2.2"},
+		// the spoofed address prepended by the client is skipped
+		{remote: "10.0.0.1:1000", xff: []string{"6.6.6.6, 2.2.2.2, 192.168.1.1"}, want: "2.2.2.2"},
+		{remote: "10.0.0.1:1000", xff: []string{"6.6.6.6", "2.2.2.2, 10.1.1.1"}, want: "2.2.2.2"},
+		{remote: "10.0.0.1:1000", xff: []string{"10.2.2.2, 10.1.1.1"}, want: "10.2.2.2"},
+		{remote: "10.0.0.1:1000", xff: []string{"2.2.2.2:4000"}, want: "2.2.2.2"},
+		{remote: "10.0.0.1:1000", xff: []string{"garbage, 10.1.1.1"}, want: "10.0.0.1"},
+		{remote: "10.0.0.1:1000", realIP: "2.2.2.2", want: "2.2.2.2"},
+		{remote: "[fd00::1]:1000", xff: []string{"2001:db8::1"}, want: "2001:db8::1"},
+		{remote: "[::ffff:10.0.0.1]:1000", xff: []string{"2.2.2.2"}, want: "2.2.2.2"},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = test.remote
+		for _, v := range test.xff {
+			req.Header.Add("X-Forwarded-For", v)
+		}
+		if test.realIP != "" {
+			req.Header.Set("X-Real-IP", test.realIP)
+		}
+		if got := r.Resolve(req); got != test.want {
+			t.Errorf("remote %s xff %v real ip %q: expected %s, got %s", test.remote, test.xff, test.realIP, test.want, got)
+		}
+	}
+}
+
+func TestParsePrefixes(t *testing.T) {
+	if _, err := ParsePrefixes([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("expected invalid cidr")
+	}
+	if _, err := ParsePrefixes([]string{"localhost"}); err == nil {
+		t.Error("expected invalid ip")
+	}
+	p, err := ParsePrefixes([]string{"10.1.2.3/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !p.ContainsString("10.200.0.1") || p.ContainsString("11.0.0.1") || p.ContainsString("") {
+		t.Errorf("unexpected prefixes %v", p)
+	}
+}
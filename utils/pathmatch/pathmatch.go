@@ -0,0 +1,243 @@
+// Package pathmatch matches requests against precompiled method, host and path rules.
+//
+// Path patterns are matched segment by segment:
+//
+//	/api/users       literal path
+//	/api/*/profile   * matches exactly one segment
+//	/api/*           a trailing * matches the rest of the path, like the endpoint paths
+//	/api/**/export   ** matches zero or more segments
+//	/api/*.json      * inside a segment matches any characters but '/'
+//	/api/{id}        {name} matches one segment, like the gorilla router
+//	/api/{id:[0-9]+} {name:regexp} matches one segment against the regexp
+//
+// The auth whitelist used to compile each path into a regexp replacing every
+// /* with /.+: a * in the middle of a path matched several segments and the
+// other regexp syntax was honoured. Such entries must now be written with **
+// and {name:regexp}, e.g. /api/**/profile and /users/{id:[0-9]+}.
+package pathmatch
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+type Rule struct {
+	Path   string
+	Method string
+	Host   string
+}
+
+type segmentKind int
+
+const (
+	kindLiteral segmentKind = iota
+	// kindSegment matches any non-empty segment.
+	kindSegment
+	// kindRegexp matches a segment against a regexp.
+	kindRegexp
+	// kindAny matches zero or more segments.
+	kindAny
+	// kindRest matches the non-empty rest of the path.
+	kindRest
+)
+
+type segment struct {
+	kind    segmentKind
+	literal string
+	re      *regexp.Regexp
+}
+
+func (s *segment) match(in string) bool {
+	switch s.kind {
+	case kindLiteral:
+		return s.literal == in
+	case kindSegment:
+		return in != ""
+	case kindRegexp:
+		return s.re.MatchString(in)
+	}
+	return false
+}
+
+type rule struct {
+	methods  map[string]struct{}
+	host     string
+	segments []segment
+}
+
+// Matcher reports whether a request matches any of its rules.
+type Matcher struct {
+	rules []*rule
+}
+
+// New compiles the rules, the `*` method matches any method and methods can be
+// listed with commas, an empty method matches none like the former whitelist.
+// An empty host matches any host, `*.example.com` matches the subdomains of example.com.
+func New(rules []Rule) (*Matcher, error) {
+	m := &Matcher{rules: make([]*rule, 0, len(rules))}
+	for _, r := range rules {
+		compiled, err := compile(r)
+		if err != nil {
+			return nil, err
+		}
+		m.rules = append(m.rules, compiled)
+	}
+	return m, nil
+}
+
+// MustNew is like New but panics if a rule cannot be compiled.
+func MustNew(rules []Rule) *Matcher {
+	m, err := New(rules)
+	if err != nil {
+		panic(err)
+	}
+	return m
+}
+
+func compile(r Rule) (*rule, error) {
+	if r.Path == "" || r.Path[0] != '/' {
+		return nil, fmt.Errorf("invalid path pattern: %q", r.Path)
+	}
+	out := &rule{host: strings.ToLower(r.Host), methods: map[string]struct{}{}}
+	if method := strings.TrimSpace(r.Method); method == "*" {
+		out.methods = nil
+	} else {
+		for _, item := range strings.Split(method, ",") {
+			item = strings.ToUpper(strings.TrimSpace(item))
+			if item == "*" {
+				out.methods = nil
+				break
+			}
+			if item != "" {
+				out.methods[item] = struct{}{}
+			}
+		}
+	}
+	parts := strings.Split(r.Path[1:], "/")
+	for i, part := range parts {
+		s, err := compileSegment(part, i == len(parts)-1)
+		if err != nil {
+			return nil, fmt.Errorf("invalid path pattern: %q: %w", r.Path, err)
+		}
+		out.segments = append(out.segments, s)
+	}
+	return out, nil
+}
+
+func compileSegment(part string, last bool) (segment, error) {
+	switch {
+	case part == "**":
+		return segment{kind: kindAny}, nil
+	case part == "*" && last:
+		return segment{kind: kindRest}, nil
+	case part == "*":
+		return segment{kind: kindSegment}, nil
+	case strings.HasPrefix(part, "{") && strings.HasSuffix(part, "}"):
+		_, expr, ok := strings.Cut(part[1:len(part)-1], ":")
+		if !ok {
+			return segment{kind: kindSegment}, nil
+		}
+		re, err := regexp.Compile("^(?:" + expr + ")$")
+		if err != nil {
+			return segment{}, err
+		}
+		return segment{kind: kindRegexp, re: re}, nil
+	case strings.Contains(part, "*"):
+		quoted := strings.Split(part, "*")
+		for i := range quoted {
+			quoted[i] = regexp.QuoteMeta(quoted[i])
+		}
+		re, err := regexp.Compile("^" + strings.Join(quoted, "[^/]*") + "$")
+		if err != nil {
+			return segment{}, err
+		}
+		return segment{kind: kindRegexp, re: re}, nil
+	}
+	return segment{kind: kindLiteral, literal: part}, nil
+}
+
+func (r *rule) matchMethod(method string) bool {
+	if r.methods == nil {
+		return true
+	}
+	_, ok := r.methods[method]
+	return ok
+}
+
+func (r *rule) matchHost(host string) bool {
+	if r.host == "" {
+		return true
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	if strings.HasPrefix(r.host, "*.") {
+		return strings.HasSuffix(host, r.host[1:])
+	}
+	return host == r.host
+}
+
+// matchSegments matches the path, which is empty or starts with '/', against the segments.
+func matchSegments(segments []segment, path string) bool {
+	for i := range segments {
+		s := &segments[i]
+		if s.kind == kindAny {
+			rest := segments[i+1:]
+			if len(rest) == 0 {
+				return true
+			}
+			for {
+				if matchSegments(rest, path) {
+					return true
+				}
+				if path == "" {
+					return false
+				}
+				next := strings.IndexByte(path[1:], '/')
+				if next < 0 {
+					return false
+				}
+				path = path[next+1:]
+			}
+		}
+		if path == "" {
+			return false
+		}
+		path = path[1:]
+		if s.kind == kindRest {
+			return path != ""
+		}
+		var part string
+		if next := strings.IndexByte(path, '/'); next < 0 {
+			part, path = path, ""
+		} else {
+			part, path = path[:next], path[next:]
+		}
+		if !s.match(part) {
+			return false
+		}
+	}
+	return path == ""
+}
+
+// Match reports whether any rule matches the method, host and path.
+func (m *Matcher) Match(method, host, path string) bool {
+	if m == nil {
+		return false
+	}
+	for _, r := range m.rules {
+		if r.matchMethod(method) && r.matchHost(host) && matchSegments(r.segments, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchRequest reports whether any rule matches the request.
+func (m *Matcher) MatchRequest(req *http.Request) bool {
+	return m.Match(req.Method, req.Host, req.URL.Path)
+}
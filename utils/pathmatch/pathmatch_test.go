@@ -0,0 +1,120 @@
+package pathmatch
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		rule   Rule
+		method string
+		host   string
+		path   string
+		result bool
+	}{
+		{Rule{Path: "/hello/*", Method: "POST"}, "POST", "", "/hello/lihua", true},
+		{Rule{Path: "/hello/*", Method: "POST"}, "POST", "", "/hello/li/hua", true},
+		{Rule{Path: "/hello/*", Method: "POST"}, "POST", "", "/hello/", false},
+		{Rule{Path: "/hello/*", Method: "POST"}, "GET", "", "/hello/lihua", false},
+		{Rule{Path: "/welcome/lihua", Method: "POST"}, "POST", "", "/welcome/lihua", true},
+		{Rule{Path: "/welcome/lihua", Method: "POST"}, "POST", "", "/welcome/lihua1", false},
+		{Rule{Path: "/welcome/lihua", Method: "POST"}, "POST", "", "/welcome/li", false},
+		{Rule{Path: "/api/*/profile", Method: "*"}, "GET", "", "/api/users/profile", true},
+		{Rule{Path: "/api/*/profile", Method: "*"}, "GET", "", "/api/users/1/profile", false},
+		{Rule{Path: "/api/*/profile", Method: "*"}, "GET", "", "/api//profile", false},
+		{Rule{Path: "/api/**/export", Method: "*"}, "GET", "", "/api/export", true},
+		{Rule{Path: "/api/**/export", Method: "*"}, "GET", "", "/api/a/b/c/export", true},
+		{Rule{Path: "/api/**/export", Method: "*"}, "GET", "", "/api/a/b/c/export/x", false},
+		{Rule{Path: "/api/**", Method: "*"}, "GET", "", "/api", true},
+		{Rule{Path: "/api/**", Method: "*"}, "GET", "", "/api/a/b", true},
+		{Rule{Path: "/api/**", Method: "*"}, "GET", "", "/apis", false},
+		{Rule{Path: "/files/*.json/meta", Method: "*"}, "GET", "", "/files/a.json/meta", true},
+		{Rule{Path: "/files/*.json/meta", Method: "*"}, "GET", "", "/files/a.yaml/meta", false},
+		{Rule{Path: "/users/{id}", Method: "*"}, "GET", "", "/users/10", true},
+		{Rule{Path: "/users/{id}", Method: "*"}, "GET", "", "/users/10/orders", false},
+		{Rule{Path: "/users/{id:[0-9]+}/orders", Method: "*"}, "GET", "", "/users/10/orders", true},
+		{Rule{Path: "/users/{id:[0-9]+}/orders", Method: "*"}, "GET", "", "/users/abc/orders", false},
+		{Rule{Path: "/ping", Method: "GET, HEAD"}, "HEAD", "", "/ping", true},
+		{Rule{Path: "/ping", Method: "GET, HEAD"}, "POST", "", "/ping", false},
+		{Rule{Path: "/ping", Method: "*"}, "DELETE", "", "/ping", true},
+		{Rule{Path: "/ping", Method: "GET,"}, "POST", "", "/ping", false},
+		// an empty method matches none, like the former whitelist
+		{Rule{Path: "/ping"}, "GET", "", "/ping", false},
+		{Rule{Path: "/ping", Method: " "}, "GET", "", "/ping", false},
+		{Rule{Path: "/ping", Method: "GET,*"}, "PUT", "", "/ping", true},
+		// a * in the middle of a path no longer matches several segments
+		{Rule{Path: "/api/*/profile", Method: "*"}, "GET", "", "/api/a/b/profile", false},
+		{Rule{Path: "/api/**/profile", Method: "*"}, "GET", "", "/api/a/b/profile", true},
+		{Rule{Path: "/ping", Method: "*", Host: "api.example.com"}, "GET", "API.example.com:8080", "/ping", true},
+		{Rule{Path: "/ping", Method: "*", Host: "api.example.com"}, "GET", "www.example.com", "/ping", false},
+		{Rule{Path: "/ping", Method: "*", Host: "*.example.com"}, "GET", "www.example.com", "/ping", true},
+		{Rule{Path: "/ping", Method: "*", Host: "*.example.com"}, "GET", "example.com", "/ping", false},
+	}
+	for _, tt := range tests {
+		m, err := New([]Rule{tt.rule})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Match(tt.method, tt.host, tt.path) != tt.result {
+			t.Errorf("%+v: %s %s%s should be %v", tt.rule, tt.method, tt.host, tt.path, tt.result)
+		}
+	}
+}
+
+func TestInvalidRule(t *testing.T) {
+	for _, path := range []string{"", "api", "/users/{id:[0-9}"} {
+		if _, err := New([]Rule{{Path: path}}); err == nil {
+			t.Errorf("%q should be invalid", path)
+		}
+	}
+}
+
+// legacyIsWhitelist is the matcher used by the auth middleware before the rules were precompiled.
+func legacyIsWhitelist(rules []Rule, method, path string) bool {
+	for _, item := range rules {
+		if method != item.Method {
+			continue
+		}
+		pattern := "^" + item.Path + "$"
+		pattern = regexp.MustCompile(`/\*`).ReplaceAllString(pattern, "/.+")
+		re := regexp.MustCompile(pattern)
+		if re.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+func benchmarkRules(n int) []Rule {
+	rules := make([]Rule, 0, n)
+	for i := 0; i < n; i++ {
+		rules = append(rules, Rule{Path: fmt.Sprintf("/api/v1/service%d/*", i), Method: "GET"})
+	}
+	return rules
+}
+
+func BenchmarkMatch(b *testing.B) {
+	for _, n := range []int{1, 10, 100} {
+		rules := benchmarkRules(n)
+		path := fmt.Sprintf("/api/v1/service%d/users/10", n-1)
+		b.Run(fmt.Sprintf("legacy/%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !legacyIsWhitelist(rules, "GET", path) {
+					b.Fatal("no match")
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("pathmatch/%d", n), func(b *testing.B) {
+			m := MustNew(rules)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if !m.Match("GET", "", path) {
+					b.Fatal("no match")
+				}
+			}
+		})
+	}
+}